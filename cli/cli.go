@@ -4,10 +4,14 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/WOo0W/bowerbird/model"
 	"github.com/WOo0W/bowerbird/server"
+	"github.com/WOo0W/bowerbird/storage"
 
 	"github.com/WOo0W/bowerbird/downloader"
 	"github.com/WOo0W/bowerbird/helper"
@@ -16,7 +20,11 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 
 	"github.com/WOo0W/bowerbird/cli/log"
+	"github.com/WOo0W/bowerbird/helper/export"
+	"github.com/WOo0W/bowerbird/helper/filter"
+	"github.com/WOo0W/bowerbird/helper/history"
 	pixivh "github.com/WOo0W/bowerbird/helper/pixiv"
+	"github.com/WOo0W/bowerbird/helper/ugoira"
 
 	"github.com/WOo0W/bowerbird/config"
 	"github.com/urfave/cli/v2"
@@ -39,8 +47,55 @@ func New() *cli.App {
 		pixivapi *pixiv.AppAPI
 		pixivrhc *retryablehttp.Client
 		pixivdl  *downloader.Downloader
+		// pixivOpts defines the paths of downloaded files
+		// and the conversion of ugoira
+		pixivOpts *pixivh.DownloadOptions
+		// pixivExpr is the filter expression from config and the filter flag
+		pixivExpr *filter.Expr
 	)
 
+	initPixivDownloader := func() error {
+		trd := &http.Transport{}
+		err := helper.SetTransportProxy(trd, conf.Pixiv.DownloaderProxy, conf.Network.GlobalProxy)
+		if err != nil {
+			return err
+		}
+		pixivdl = downloader.NewWithCliet(ctx, &http.Client{Transport: trd})
+		err = applyDownloaderConfig(pixivdl, &conf.Downloader)
+		if err != nil {
+			return err
+		}
+		pixivOpts = &pixivh.DownloadOptions{BasePath: conf.Storage.ParsedPixiv()}
+		pixivOpts.Paths, err = pixivh.ParsePathTemplates(&conf.Storage.PathTemplates)
+		if err != nil {
+			return fmt.Errorf("parsing path templates: %w", err)
+		}
+		if conf.Pixiv.UgoiraFormat != "" {
+			pixivOpts.Ugoira, err = ugoira.NewConverter(conf.Pixiv.UgoiraFormat, conf.Pixiv.UgoiraFallbackFormat, conf.System.FFmpegCommand)
+			if err != nil {
+				return err
+			}
+			if pixivOpts.Ugoira == nil {
+				logger.Warn("ffmpeg not found, only the zips of ugoira will be saved")
+			} else if pixivOpts.Ugoira.Format != conf.Pixiv.UgoiraFormat {
+				logger.Warn(fmt.Sprintf("ffmpeg not found, ugoira will be converted to %s", pixivOpts.Ugoira.Format))
+			}
+		}
+		pixivdl.StagingDir = conf.Storage.ParsedPixiv()
+		pixivdl.Storage, err = storage.ForSource(&conf.Storage, "pixiv", pixivdl.StagingDir)
+		if err != nil {
+			return fmt.Errorf("opening pixiv storage: %w", err)
+		}
+		pixivdl.Journal, err = downloader.OpenJournal(conf.Storage.ParsedDownloadJournal())
+		if err != nil {
+			return fmt.Errorf("opening download journal: %w", err)
+		}
+		if n := pixivdl.Journal.Len(); n > 0 {
+			logger.Warn(n, "unfinished tasks found in download journal. Run `bowerbird downloads resume` to continue them.")
+		}
+		return nil
+	}
+
 	initPixiv := func() error {
 		pixivrhc = retryablehttp.NewClient()
 		pixivrhc.Backoff = helper.DefaultBackoff
@@ -56,12 +111,10 @@ func New() *cli.App {
 		pixivapi = pixiv.NewWithClient(pixivrhc.StandardClient())
 		pixivapi.SetLanguage(conf.Pixiv.Language)
 
-		trd := &http.Transport{}
-		err = helper.SetTransportProxy(trd, conf.Pixiv.DownloaderProxy, conf.Network.GlobalProxy)
+		err = initPixivDownloader()
 		if err != nil {
 			return err
 		}
-		pixivdl = downloader.NewWithCliet(ctx, &http.Client{Transport: trd})
 
 		err = authPixiv(pixivapi, conf)
 		if err != nil {
@@ -76,6 +129,42 @@ func New() *cli.App {
 		return nil
 	}
 
+	// pixivIncremental returns the incremental options of the feed
+	// with the checkpoint of the last run loaded,
+	// or nil if `--incremental` is not given.
+	pixivIncremental := func(c *cli.Context, feed string, uid int, restrict pixiv.Restrict) *pixivh.Incremental {
+		if c.Int("incremental") <= 0 || db == nil {
+			return nil
+		}
+		inc := &pixivh.Incremental{
+			Stop: c.Int("incremental"),
+			Checkpoint: &model.Checkpoint{
+				Source:   model.SourcePixiv,
+				Feed:     feed,
+				UserID:   strconv.Itoa(uid),
+				Restrict: string(restrict),
+			},
+		}
+		ok, err := pixivh.LoadCheckpoint(ctx, db, inc.Checkpoint)
+		if err != nil {
+			logger.Error(err)
+			inc.Checkpoint = nil
+		} else if ok {
+			logger.Info(fmt.Sprintf("Resuming %s of user %d from the last run", feed, uid))
+		}
+		return inc
+	}
+
+	// pixivFilter returns the Filter from the flags.
+	pixivFilter := func(c *cli.Context) *pixivh.Filter {
+		return &pixivh.Filter{
+			Tags:         c.StringSlice("tags"),
+			TagsMatchAll: c.Bool("tags-match-all"),
+			MinBookmarks: c.Int("min-bookmarks"),
+			Expr:         pixivExpr,
+		}
+	}
+
 	return &cli.App{
 		Name:    "Bowerbird",
 		Usage:   "A toolset to manage your collection",
@@ -124,13 +213,339 @@ func New() *cli.App {
 			{
 				Name: "serve",
 				Action: func(c *cli.Context) error {
-					err := server.Serve(conf, db)
+					err := initPixivDownloader()
+					if err != nil {
+						logger.Error(err)
+						return nil
+					}
+					pixivdl.Start()
+					err = server.Serve(ctx, conf, db, pixivdl)
 					if err != nil {
 						logger.Error(err)
 					}
 					return nil
 				},
 			},
+			{
+				Name:  "downloads",
+				Usage: "Manage the download queue",
+				Before: func(c *cli.Context) error {
+					err := initPixivDownloader()
+					if err != nil {
+						logger.Error(err)
+						return cli.Exit("", 1)
+					}
+					return nil
+				},
+				Subcommands: []*cli.Command{
+					{
+						Name:  "resume",
+						Usage: "Download the unfinished tasks saved in the journal",
+						Action: func(c *cli.Context) error {
+							n, err := pixivh.ResumeTasks(ctx, pixivdl, conf.Storage.ParsedPixiv(), db)
+							if err != nil {
+								logger.Error(err)
+								return nil
+							}
+							logger.Info(n, "tasks were sent to download queue")
+							pixivdl.Start()
+							downloaderUILoop(pixivdl, pixivOpts)
+							return nil
+						},
+					},
+					{
+						Name:  "list",
+						Usage: "List the unfinished tasks saved in the journal",
+						Action: func(c *cli.Context) error {
+							ts, err := pixivdl.Journal.Tasks()
+							if err != nil {
+								logger.Error(err)
+								return nil
+							}
+							for _, t := range ts {
+								fmt.Printf("%d\t%s\t%s\n", t.ID, t.Request.URL, t.LocalPath)
+							}
+							pixivdl.Journal.Close()
+							return nil
+						},
+					},
+				},
+			},
+			{
+				Name:  "storage",
+				Usage: "Manage the downloaded files",
+				Before: func(c *cli.Context) error {
+					if db == nil {
+						logger.Error("Can only manage storage when database enabled")
+						return cli.Exit("", 1)
+					}
+					return nil
+				},
+				Subcommands: []*cli.Command{
+					{
+						Name:  "dedupe",
+						Usage: "Replace the files with the same content with hard links",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{
+								Name:  "dry-run",
+								Usage: "Report the duplicated files only",
+							},
+						},
+						Action: func(c *cli.Context) error {
+							s, err := storage.ForSource(&conf.Storage, "pixiv", conf.Storage.ParsedPixiv())
+							if err != nil {
+								logger.Error(err)
+								return nil
+							}
+							l, ok := s.(*storage.Local)
+							if !ok {
+								logger.Error("Can only dedupe files on local storage")
+								return nil
+							}
+							err = pixivh.DedupeMedia(ctx, db, l.Root, c.Bool("dry-run"))
+							if err != nil {
+								logger.Error(err)
+							}
+							return nil
+						},
+					},
+					{
+						Name:  "check",
+						Usage: "Check the downloaded files for missing, broken and orphan files",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{
+								Name:  "repair",
+								Usage: "Download the missing and broken files again",
+							},
+						},
+						Action: func(c *cli.Context) error {
+							if !c.Bool("repair") {
+								s, err := storage.ForSource(&conf.Storage, "pixiv", conf.Storage.ParsedPixiv())
+								if err != nil {
+									logger.Error(err)
+									return nil
+								}
+								_, err = pixivh.CheckMedia(ctx, db, s, conf.Storage.ParsedPixiv(), nil)
+								if err != nil {
+									logger.Error(err)
+								}
+								return nil
+							}
+
+							err := initPixivDownloader()
+							if err != nil {
+								logger.Error(err)
+								return nil
+							}
+							n, err := pixivh.CheckMedia(ctx, db, pixivdl.Storage, pixivdl.StagingDir, pixivdl)
+							if err != nil {
+								logger.Error(err)
+							}
+							logger.Info(n, "tasks were sent to download queue")
+							pixivdl.Start()
+							downloaderUILoop(pixivdl, pixivOpts)
+							return nil
+						},
+					},
+					{
+						Name:  "reorganize",
+						Usage: "Move the downloaded files to the paths from the path templates in config",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{
+								Name:  "dry-run",
+								Usage: "Report the files to be moved only",
+							},
+						},
+						Action: func(c *cli.Context) error {
+							paths, err := pixivh.ParsePathTemplates(&conf.Storage.PathTemplates)
+							if err != nil {
+								logger.Error(err)
+								return nil
+							}
+							s, err := storage.ForSource(&conf.Storage, "pixiv", conf.Storage.ParsedPixiv())
+							if err != nil {
+								logger.Error(err)
+								return nil
+							}
+							err = pixivh.ReorganizeMedia(ctx, db, s, paths, c.Bool("dry-run"))
+							if err != nil {
+								logger.Error(err)
+							}
+							return nil
+						},
+					},
+					{
+						Name:  "removed",
+						Usage: "List the works removed from bookmarks or invisible on pixiv, with the files kept",
+						Action: func(c *cli.Context) error {
+							s, err := storage.ForSource(&conf.Storage, "pixiv", conf.Storage.ParsedPixiv())
+							if err != nil {
+								logger.Error(err)
+								return nil
+							}
+							ws, err := pixivh.RemovedWorks(ctx, db, s)
+							if err != nil {
+								logger.Error(err)
+								return nil
+							}
+							for _, w := range ws {
+								fmt.Println(pixivh.RemovedWorkString(w))
+								for _, f := range w.Files {
+									fmt.Printf("\t%s\n", f)
+								}
+								for _, f := range w.Missing {
+									fmt.Printf("\t%s (missing)\n", f)
+								}
+							}
+							logger.Info(len(ws), "works were found")
+							return nil
+						},
+					},
+				},
+			},
+			{
+				Name:  "history",
+				Usage: "List the versions of the details of a post or user with the changes",
+				Before: func(c *cli.Context) error {
+					if db == nil {
+						logger.Error("Can only list versions when database enabled")
+						return cli.Exit("", 1)
+					}
+					return nil
+				},
+				Subcommands: []*cli.Command{
+					{
+						Name:      "post",
+						Usage:     "List the versions of a pixiv illust or novel",
+						ArgsUsage: "<illust or novel ID>",
+						Flags: []cli.Flag{
+							&cli.BoolFlag{
+								Name:  "novel",
+								Usage: "The ID is of a novel",
+							},
+						},
+						Action: func(c *cli.Context) error {
+							source := model.PostSourcePixivIllust
+							if c.Bool("novel") {
+								source = model.PostSourcePixivNovel
+							}
+							id, err := history.PostID(ctx, db, source, c.Args().First())
+							if err != nil {
+								logger.Error(err)
+								return nil
+							}
+							vs, err := history.PostVersions(ctx, db, id)
+							if err != nil {
+								logger.Error(err)
+								return nil
+							}
+							printVersions(vs)
+							return nil
+						},
+					},
+					{
+						Name:      "user",
+						Usage:     "List the versions of a pixiv user profile",
+						ArgsUsage: "<user ID>",
+						Action: func(c *cli.Context) error {
+							id, err := history.UserID(ctx, db, model.SourcePixiv, c.Args().First())
+							if err != nil {
+								logger.Error(err)
+								return nil
+							}
+							vs, err := history.UserVersions(ctx, db, id)
+							if err != nil {
+								logger.Error(err)
+								return nil
+							}
+							printVersions(vs)
+							return nil
+						},
+					},
+				},
+			},
+			{
+				Name:  "export",
+				Usage: "Export the archived works as books",
+				Before: func(c *cli.Context) error {
+					if db == nil {
+						logger.Error("Can only export works when database enabled")
+						return cli.Exit("", 1)
+					}
+					return nil
+				},
+				Subcommands: []*cli.Command{
+					{
+						Name:      "novel",
+						Usage:     "Export a pixiv novel, a novel series or all novels of a user as EPUB or Markdown",
+						ArgsUsage: "[novel ID]",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:    "format",
+								Aliases: []string{"f"},
+								Value:   "epub",
+								Usage:   "Export as epub or markdown",
+							},
+							&cli.StringFlag{
+								Name:    "output",
+								Aliases: []string{"o"},
+								Value:   ".",
+								Usage:   "The directory to write the files to",
+							},
+							&cli.StringFlag{
+								Name:  "series",
+								Usage: "Export the novel series with the ID as a book",
+							},
+							&cli.StringFlag{
+								Name:    "user",
+								Aliases: []string{"u"},
+								Usage:   "Export every novel of the pixiv user with the ID",
+							},
+						},
+						Action: func(c *cli.Context) error {
+							s, err := storage.ForSource(&conf.Storage, "pixiv", conf.Storage.ParsedPixiv())
+							if err != nil {
+								logger.Error(err)
+								return nil
+							}
+							var bs []*export.Book
+							switch {
+							case c.IsSet("series"):
+								var b *export.Book
+								b, err = pixivh.NovelSeriesBook(ctx, db, s, c.String("series"))
+								bs = []*export.Book{b}
+							case c.IsSet("user"):
+								bs, err = pixivh.UserNovelBooks(ctx, db, s, c.String("user"))
+							case c.Args().Len() == 1:
+								var b *export.Book
+								b, err = pixivh.NovelBook(ctx, db, s, c.Args().First())
+								bs = []*export.Book{b}
+							default:
+								logger.Error("Give a novel ID, --series or --user")
+								return nil
+							}
+							if err != nil {
+								logger.Error(err)
+								return nil
+							}
+
+							if err := os.MkdirAll(c.String("output"), 0755); err != nil {
+								logger.Error(err)
+								return nil
+							}
+							for _, b := range bs {
+								fp, err := writeBook(b, c.String("format"), c.String("output"))
+								if err != nil {
+									logger.Error(err)
+									return nil
+								}
+								logger.Info("Exported", fp)
+							}
+							return nil
+						},
+					},
+				},
+			},
 			{
 				Name:  "pixiv",
 				Usage: "Get works from pixiv.net",
@@ -154,8 +569,31 @@ func New() *cli.App {
 						Aliases: []string{"l"},
 						Usage:   "Limit how many items to download",
 					},
+					&cli.IntFlag{
+						Name:  "incremental",
+						Usage: "Stop after the given number of consecutive works already archived, and resume from where the last run stopped",
+					},
+					&cli.StringFlag{
+						Name:    "filter",
+						Aliases: []string{"f"},
+						Usage:   "Get items matching the expression like 'bookmarks > 1000 && !tag(\"R-18\")', in addition to Pixiv.Filter in config",
+					},
 				},
 				Before: func(c *cli.Context) error {
+					var exprs []string
+					for _, s := range []string{conf.Pixiv.Filter, c.String("filter")} {
+						if strings.TrimSpace(s) != "" {
+							exprs = append(exprs, "("+s+")")
+						}
+					}
+					if len(exprs) != 0 {
+						var err error
+						pixivExpr, err = filter.Parse(strings.Join(exprs, " && "))
+						if err != nil {
+							logger.Error(err)
+							return cli.Exit("", 1)
+						}
+					}
 					err := initPixiv()
 					if err != nil {
 						logger.Error(err)
@@ -164,6 +602,27 @@ func New() *cli.App {
 					return nil
 				},
 				Subcommands: []*cli.Command{
+					{
+						Name:  "sync-following",
+						Usage: "Save the users followed by the logged user and their new uploaded works",
+						Action: func(c *cli.Context) error {
+							if db == nil {
+								logger.Error("Followed users are not saved without database.")
+								return nil
+							}
+							stop := c.Int("incremental")
+							if stop <= 0 {
+								stop = 30
+							}
+							pixivdl.Start()
+							err := pixivh.SyncFollowing(ctx, c.Int("limit"), stop, pixivdl, pixivapi, pixivOpts, pixivFilter(c), db, dbOnly)
+							if err != nil {
+								logger.Error(err)
+							}
+							downloaderUILoop(pixivdl, pixivOpts)
+							return nil
+						},
+					},
 					{
 						Name:  "update-users",
 						Usage: "Update user profile in database",
@@ -188,10 +647,47 @@ func New() *cli.App {
 							} else {
 								du = 240 * time.Hour
 							}
-							err := pixivh.UpdateAllUsers(ctx, db, pixivapi, c.Bool("all"), du)
+							if dbOnly {
+								err := pixivh.UpdateAllUsers(ctx, db, pixivapi, nil, "", c.Bool("all"), du)
+								if err != nil {
+									logger.Error(err)
+								}
+								return nil
+							}
+							pixivdl.Start()
+							err := pixivh.UpdateAllUsers(ctx, db, pixivapi, pixivdl, pixivOpts.BasePath, c.Bool("all"), du)
+							if err != nil {
+								logger.Error(err)
+							}
+							downloaderUILoop(pixivdl, pixivOpts)
+							return nil
+						},
+					},
+					{
+						Name:  "refresh-posts",
+						Usage: "Fetch the illusts and novels in database again to update their stats, tags and details",
+						Flags: []cli.Flag{
+							&cli.DurationFlag{
+								Name:  "before",
+								Usage: "Refresh the works which were updated before the duration till now",
+								Value: 720 * time.Hour,
+							},
+						},
+						Action: func(c *cli.Context) error {
+							if !conf.Database.Enabled {
+								logger.Error("Works can only be refreshed with database.")
+								return nil
+							}
+							if !dbOnly {
+								pixivdl.Start()
+							}
+							err := pixivh.RefreshPosts(ctx, db, pixivapi, pixivdl, pixivOpts, c.Duration("before"), dbOnly)
 							if err != nil {
 								logger.Error(err)
 							}
+							if !dbOnly {
+								downloaderUILoop(pixivdl, pixivOpts)
+							}
 							return nil
 						},
 					},
@@ -222,11 +718,17 @@ func New() *cli.App {
 
 									uid := getPixivUserFlag(c, pixivapi.UserID)
 
+									inc := pixivIncremental(c, "illust-bookmarks", uid, restrict)
+
 									var opt *pixiv.BookmarkQuery
 									if c.IsSet("max-bookmark-id") {
 										opt = &pixiv.BookmarkQuery{
 											MaxBookmarkID: c.Int("max-bookmark-id"),
 										}
+									} else if inc != nil && inc.Checkpoint != nil && inc.Checkpoint.MaxBookmarkID != 0 {
+										opt = &pixiv.BookmarkQuery{
+											MaxBookmarkID: inc.Checkpoint.MaxBookmarkID,
+										}
 									}
 
 									r, err := pixivapi.User.BookmarkedIllusts(uid, restrict, opt)
@@ -234,10 +736,16 @@ func New() *cli.App {
 										logger.Error(err)
 										return nil
 									}
+									bs := &pixivh.BookmarkSync{
+										Source:    model.PostSourcePixivIllust,
+										UserID:    strconv.Itoa(uid),
+										Restrict:  string(restrict),
+										FromStart: opt == nil,
+									}
 
 									pixivdl.Start()
-									pixivh.ProcessIllusts(ctx, r, c.Int("limit"), pixivdl, pixivapi, conf.Storage.ParsedPixiv(), c.StringSlice("tags"), c.Bool("tags-match-all"), db, dbOnly)
-									downloaderUILoop(pixivdl)
+									pixivh.ProcessIllusts(ctx, r, c.Int("limit"), pixivdl, pixivapi, pixivOpts, pixivFilter(c), inc, bs, db, dbOnly)
+									downloaderUILoop(pixivdl, pixivOpts)
 									return nil
 								},
 							},
@@ -247,8 +755,13 @@ func New() *cli.App {
 								Action: func(c *cli.Context) error {
 									uid := getPixivUserFlag(c, pixivapi.UserID)
 
+									inc := pixivIncremental(c, "illust-uploads", uid, "")
+
 									var opt *pixiv.IllustQuery
 									offset := c.Int("offset")
+									if offset == 0 && inc != nil && inc.Checkpoint != nil {
+										offset = inc.Checkpoint.Offset
+									}
 									if offset > 0 {
 										opt = &pixiv.IllustQuery{
 											Offset: offset,
@@ -261,8 +774,105 @@ func New() *cli.App {
 									}
 
 									pixivdl.Start()
-									pixivh.ProcessIllusts(ctx, ri, c.Int("limit"), pixivdl, pixivapi, conf.Storage.ParsedPixiv(), c.StringSlice("tags"), c.Bool("tags-match-all"), db, dbOnly)
-									downloaderUILoop(pixivdl)
+									pixivh.ProcessIllusts(ctx, ri, c.Int("limit"), pixivdl, pixivapi, pixivOpts, pixivFilter(c), inc, nil, db, dbOnly)
+									downloaderUILoop(pixivdl, pixivOpts)
+									return nil
+								},
+							},
+							{
+								Name:      "search",
+								Usage:     "Search works by keywords",
+								ArgsUsage: "<query>",
+								Flags:     pixivSearchFlags("tags"),
+								Action: func(c *cli.Context) error {
+									word, q, err := getPixivSearch(c)
+									if err != nil {
+										logger.Error(err)
+										return nil
+									}
+									ri, err := pixivapi.Search.Illusts(word, q)
+									if err != nil {
+										logger.Error(err)
+										return nil
+									}
+
+									pixivdl.Start()
+									pixivh.ProcessIllusts(ctx, ri, c.Int("limit"), pixivdl, pixivapi, pixivOpts, pixivFilter(c), nil, nil, db, dbOnly)
+									downloaderUILoop(pixivdl, pixivOpts)
+									return nil
+								},
+							},
+							{
+								Name:  "ranking",
+								Usage: "Get the ranking works",
+								Flags: pixivRankingFlags(),
+								Action: func(c *cli.Context) error {
+									q, err := getPixivRanking(c)
+									if err != nil {
+										logger.Error(err)
+										return nil
+									}
+									ri, err := pixivapi.Illust.Ranking(q)
+									if err != nil {
+										logger.Error(err)
+										return nil
+									}
+
+									pixivdl.Start()
+									pixivh.ProcessIllusts(ctx, ri, c.Int("limit"), pixivdl, pixivapi, pixivOpts, pixivFilter(c), nil, nil, db, dbOnly)
+									downloaderUILoop(pixivdl, pixivOpts)
+									return nil
+								},
+							},
+							{
+								Name:      "series",
+								Usage:     "Get all works in the illust series in order",
+								ArgsUsage: "<series ID>",
+								Action: func(c *cli.Context) error {
+									id, err := strconv.Atoi(c.Args().First())
+									if err != nil {
+										logger.Error("Invalid series ID:", c.Args().First())
+										return nil
+									}
+
+									pixivdl.Start()
+									err = pixivh.ProcessIllustSeries(ctx, id, c.Int("limit"), pixivdl, pixivapi, pixivOpts, pixivFilter(c), db, dbOnly)
+									if err != nil {
+										logger.Error(err)
+									}
+									downloaderUILoop(pixivdl, pixivOpts)
+									return nil
+								},
+							},
+							{
+								Name:  "following",
+								Usage: "Get the new works of followed users",
+								Flags: []cli.Flag{
+									&cli.BoolFlag{
+										Name:  "private",
+										Usage: "Get the works of users followed privately",
+									},
+								},
+								Action: func(c *cli.Context) error {
+									restrict := pixiv.RPublic
+									if c.Bool("private") {
+										restrict = pixiv.RPrivate
+									}
+									// the feed can't be resumed from a checkpoint
+									var inc *pixivh.Incremental
+									if c.Int("incremental") > 0 {
+										inc = &pixivh.Incremental{Stop: c.Int("incremental")}
+									}
+
+									ri, err := pixivapi.Illust.NewFromFollowings(restrict)
+									if err != nil {
+										logger.Error(err)
+										return nil
+									}
+
+									pixivdl.Start()
+									pixivh.ProcessIllusts(ctx, ri, c.Int("limit"), pixivdl, pixivapi, pixivOpts, pixivFilter(c), inc, nil, db, dbOnly)
+									downloaderUILoop(pixivdl, pixivOpts)
 									return nil
 								},
 							},
@@ -288,10 +898,10 @@ func New() *cli.App {
 							{
 								Name: "bookmarks",
 								Flags: []cli.Flag{
-									// &cli.BoolFlag{
-									// 	Name:  "save-series",
-									// 	Usage: "Save full series of each bookmarked novel.",
-									// },
+									&cli.BoolFlag{
+										Name:  "save-series",
+										Usage: "Save full series of each bookmarked novel",
+									},
 									&cli.BoolFlag{
 										Name:  "private",
 										Usage: "Download the private bookmarks only",
@@ -323,12 +933,26 @@ func New() *cli.App {
 										logger.Error(err)
 										return nil
 									}
-									pixivh.ProcessNovels(ctx, rn, c.Int("limit"), pixivapi, db, c.StringSlice("tags"), c.Bool("tags-match-all"), c.Bool("force-update"))
+									bs := &pixivh.BookmarkSync{
+										Source:    model.PostSourcePixivNovel,
+										UserID:    strconv.Itoa(uid),
+										Restrict:  string(restrict),
+										FromStart: opt == nil,
+									}
+									pixivdl.Start()
+									pixivh.ProcessNovels(ctx, rn, c.Int("limit"), pixivdl, pixivapi, pixivOpts, pixivFilter(c), bs, db, dbOnly, c.Bool("force-update"), c.Bool("save-series"))
+									downloaderUILoop(pixivdl, pixivOpts)
 									return nil
 								},
 							},
 							{
 								Name: "uploads",
+								Flags: []cli.Flag{
+									&cli.BoolFlag{
+										Name:  "save-series",
+										Usage: "Save full series of each novel",
+									},
+								},
 								Action: func(c *cli.Context) error {
 									uid := getPixivUserFlag(c, pixivapi.UserID)
 									rn, err := pixivapi.User.Novels(uid)
@@ -336,7 +960,52 @@ func New() *cli.App {
 										logger.Error(err)
 										return nil
 									}
-									pixivh.ProcessNovels(ctx, rn, c.Int("limit"), pixivapi, db, c.StringSlice("tags"), c.Bool("tags-match-all"), c.Bool("force-update"))
+									pixivdl.Start()
+									pixivh.ProcessNovels(ctx, rn, c.Int("limit"), pixivdl, pixivapi, pixivOpts, pixivFilter(c), nil, db, dbOnly, c.Bool("force-update"), c.Bool("save-series"))
+									downloaderUILoop(pixivdl, pixivOpts)
+									return nil
+								},
+							},
+							{
+								Name:      "search",
+								Usage:     "Search novels by keywords",
+								ArgsUsage: "<query>",
+								Flags:     pixivSearchFlags("tags"),
+								Action: func(c *cli.Context) error {
+									word, q, err := getPixivSearch(c)
+									if err != nil {
+										logger.Error(err)
+										return nil
+									}
+									rn, err := pixivapi.Search.Novels(word, q)
+									if err != nil {
+										logger.Error(err)
+										return nil
+									}
+									pixivdl.Start()
+									pixivh.ProcessNovels(ctx, rn, c.Int("limit"), pixivdl, pixivapi, pixivOpts, pixivFilter(c), nil, db, dbOnly, c.Bool("force-update"), false)
+									downloaderUILoop(pixivdl, pixivOpts)
+									return nil
+								},
+							},
+							{
+								Name:  "ranking",
+								Usage: "Get the ranking novels",
+								Flags: pixivRankingFlags(),
+								Action: func(c *cli.Context) error {
+									q, err := getPixivRanking(c)
+									if err != nil {
+										logger.Error(err)
+										return nil
+									}
+									rn, err := pixivapi.Novel.Ranking(q)
+									if err != nil {
+										logger.Error(err)
+										return nil
+									}
+									pixivdl.Start()
+									pixivh.ProcessNovels(ctx, rn, c.Int("limit"), pixivdl, pixivapi, pixivOpts, pixivFilter(c), nil, db, dbOnly, c.Bool("force-update"), false)
+									downloaderUILoop(pixivdl, pixivOpts)
 									return nil
 								},
 							},
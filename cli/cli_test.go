@@ -23,3 +23,14 @@ func TestConfig(t *testing.T) {
 	// t.Error(loadConfigFile(conf, path))
 
 }
+
+func TestParsePixivDate(t *testing.T) {
+	d, err := parsePixivDate("2020-1-2")
+	if err == nil {
+		t.Errorf("expected error, got %q", d)
+	}
+	d, err = parsePixivDate("2020-01-02")
+	if err != nil || d != "2020-01-02" {
+		t.Errorf("unexpected date %q: %v", d, err)
+	}
+}
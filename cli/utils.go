@@ -2,10 +2,12 @@ package cli
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"syscall"
 	"time"
 
@@ -13,6 +15,9 @@ import (
 	"github.com/WOo0W/bowerbird/cli/log"
 	"github.com/WOo0W/bowerbird/config"
 	"github.com/WOo0W/bowerbird/downloader"
+	"github.com/WOo0W/bowerbird/helper/export"
+	"github.com/WOo0W/bowerbird/helper/history"
+	pixivh "github.com/WOo0W/bowerbird/helper/pixiv"
 	"github.com/WOo0W/go-pixiv/pixiv"
 	"github.com/dustin/go-humanize"
 	"github.com/urfave/cli/v2"
@@ -100,8 +105,9 @@ func authPixiv(api *pixiv.AppAPI, c *config.Config) error {
 	return nil
 }
 
-// downloaderUILoop displays current download speed
-func downloaderUILoop(dl *downloader.Downloader) {
+// downloaderUILoop displays current download speed,
+// and waits for the ugoira of opts to be converted after the downloads.
+func downloaderUILoop(dl *downloader.Downloader, opts *pixivh.DownloadOptions) {
 	ticker := time.NewTicker(1 * time.Second)
 	defer ticker.Stop()
 
@@ -114,6 +120,35 @@ func downloaderUILoop(dl *downloader.Downloader) {
 		}
 	}()
 	dl.Wait()
+	opts.WaitUgoira()
+	if dl.Journal != nil {
+		if err := dl.Journal.Close(); err != nil {
+			dl.Logger.Error("Closing download journal:", err)
+		}
+	}
+}
+
+// applyDownloaderConfig applies the rate limits and timeouts in config to dl.
+func applyDownloaderConfig(dl *downloader.Downloader, c *config.DownloaderConfig) error {
+	global, err := c.ParsedRateLimit()
+	if err != nil {
+		return fmt.Errorf("parsing rate limit: %w", err)
+	}
+	hosts, err := c.ParsedHostRateLimits()
+	if err != nil {
+		return fmt.Errorf("parsing rate limit: %w", err)
+	}
+	dl.SetRateLimits(global, hosts)
+
+	dl.IdleTimeout, err = c.ParsedIdleTimeout()
+	if err != nil {
+		return fmt.Errorf("parsing idle timeout: %w", err)
+	}
+	dl.TaskTimeout, err = c.ParsedTaskTimeout()
+	if err != nil {
+		return fmt.Errorf("parsing task timeout: %w", err)
+	}
+	return nil
 }
 
 func connectToDB(ctx context.Context, uri string) (*mongo.Client, error) {
@@ -136,3 +171,169 @@ func getPixivUserFlag(c *cli.Context, fallbackID int) (id int) {
 	}
 	return id
 }
+
+// values of --target and --sort of pixiv search
+var (
+	pixivSearchTargets = map[string]pixiv.SearchTarget{
+		"tags":          pixiv.STPartialMatchTags,
+		"exact-tags":    pixiv.STExactMatchTags,
+		"title-caption": pixiv.STTitleCaption,
+		"text":          pixiv.STText,
+	}
+	pixivSorts = map[string]pixiv.Sort{
+		"date-desc":    pixiv.SDateDesc,
+		"date-asc":     pixiv.SDateAsc,
+		"popular-desc": pixiv.SPopularDesc,
+	}
+)
+
+func pixivSearchFlags(target string) []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:  "target",
+			Usage: "Search target: tags, exact-tags, title-caption (illusts only) or text (novels only)",
+			Value: target,
+		},
+		&cli.StringFlag{
+			Name:  "sort",
+			Usage: "Sort order: date-desc, date-asc or popular-desc (premium only)",
+			Value: "date-desc",
+		},
+		&cli.StringFlag{
+			Name:  "start-date",
+			Usage: "Get items created since the date like 2020-01-01",
+		},
+		&cli.StringFlag{
+			Name:  "end-date",
+			Usage: "Get items created until the date like 2020-12-31",
+		},
+		&cli.IntFlag{
+			Name:  "min-bookmarks",
+			Usage: "Get items with at least the given number of bookmarks",
+		},
+	}
+}
+
+func parsePixivDate(s string) (pixiv.Date, error) {
+	if s == "" {
+		return "", nil
+	}
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return "", fmt.Errorf("invalid date %q", s)
+	}
+	return pixiv.NewDate(t.Year(), int(t.Month()), t.Day()), nil
+}
+
+// getPixivSearch returns the search word and query from the arguments and flags.
+func getPixivSearch(c *cli.Context) (string, *pixiv.SearchQuery, error) {
+	word := strings.Join(c.Args().Slice(), " ")
+	if word == "" {
+		return "", nil, fmt.Errorf("no search query given")
+	}
+	q := &pixiv.SearchQuery{}
+	var ok bool
+	if q.SearchTarget, ok = pixivSearchTargets[c.String("target")]; !ok {
+		return "", nil, fmt.Errorf("unknown search target %q", c.String("target"))
+	}
+	if q.Sort, ok = pixivSorts[c.String("sort")]; !ok {
+		return "", nil, fmt.Errorf("unknown sort %q", c.String("sort"))
+	}
+	var err error
+	if q.StartDate, err = parsePixivDate(c.String("start-date")); err != nil {
+		return "", nil, err
+	}
+	if q.EndDate, err = parsePixivDate(c.String("end-date")); err != nil {
+		return "", nil, err
+	}
+	return word, q, nil
+}
+
+func pixivRankingFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:  "mode",
+			Usage: "Ranking mode like day, week, month, day_male, day_female, week_original, week_rookie or day_manga",
+			Value: string(pixiv.RMDay),
+		},
+		&cli.StringFlag{
+			Name:  "date",
+			Usage: "The date of ranking like 2020-01-01. Default: the latest",
+		},
+	}
+}
+
+// getPixivRanking returns the ranking query from the flags.
+func getPixivRanking(c *cli.Context) (*pixiv.RankingQuery, error) {
+	date, err := parsePixivDate(c.String("date"))
+	if err != nil {
+		return nil, err
+	}
+	return &pixiv.RankingQuery{
+		Mode: pixiv.RankingMode(c.String("mode")),
+		Date: string(date),
+	}, nil
+}
+
+// writeBook writes b to dir in the format "epub" or "markdown",
+// and returns the path of the file.
+// The images of Markdown are written to "images" under dir.
+func writeBook(b *export.Book, format, dir string) (string, error) {
+	var fp string
+	switch format {
+	case "epub":
+		fp = filepath.Join(dir, b.FileName(".epub"))
+	case "markdown", "md":
+		fp = filepath.Join(dir, b.FileName(".md"))
+		imageDir := filepath.Join(dir, "images")
+		if err := os.MkdirAll(imageDir, 0755); err != nil {
+			return "", err
+		}
+		for _, im := range b.Images() {
+			if err := ioutil.WriteFile(filepath.Join(imageDir, im.Name), im.Data, 0644); err != nil {
+				return "", err
+			}
+		}
+	default:
+		return "", fmt.Errorf("unknown format %q", format)
+	}
+
+	f, err := os.Create(fp)
+	if err != nil {
+		return "", err
+	}
+	if format == "epub" {
+		err = export.WriteEPUB(f, b)
+	} else {
+		err = export.WriteMarkdown(f, b, "images")
+	}
+	if err != nil {
+		f.Close()
+		return "", err
+	}
+	return fp, f.Close()
+}
+
+// printVersions prints the detail versions with the changes between them.
+// The long values are truncated.
+func printVersions(vs []*history.Version) {
+	value := func(v interface{}) string {
+		if v == nil {
+			return "(none)"
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		if r := []rune(string(b)); len(r) > 80 {
+			return string(r[:80]) + "..."
+		}
+		return string(b)
+	}
+	for i, v := range vs {
+		fmt.Printf("#%d %s (%s)\n", i+1, v.SavedAt.Local().Format("2006-01-02 15:04:05"), v.ID.Hex())
+		for _, c := range v.Changes {
+			fmt.Printf("\t%s: %s -> %s\n", c.Field, value(c.Old), value(c.New))
+		}
+	}
+}
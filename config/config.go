@@ -4,9 +4,13 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"time"
+
+	"github.com/dustin/go-humanize"
 )
 
 // The version of config and app
@@ -36,6 +40,8 @@ type Config struct {
 	Network  NetworkConfig
 	System   SystemConfig
 
+	Downloader DownloaderConfig
+
 	Pixiv PixivConfig
 
 	Path string `json:"-"`
@@ -55,6 +61,53 @@ type LogConfig struct {
 type StorageConfig struct {
 	RootDir string
 	Pixiv   string
+	// DownloadJournal is the file saving unfinished download tasks.
+	DownloadJournal string
+	// Backends maps the source like "pixiv" to where its files are stored.
+	// The files of sources not in Backends are stored in local directories.
+	Backends map[string]BackendConfig
+	// PathTemplates defines the paths of downloaded files
+	// relative to the directory of the source.
+	PathTemplates PathTemplatesConfig
+}
+
+// PathTemplatesConfig defines the templates of paths of downloaded files.
+//
+// The placeholders of pixiv illusts are {user.id}, {user.name}, {user.account},
+// {illust.id}, {illust.title}, {illust.type}, {date} like "20200202123456",
+// {page} counted from 0, {file} like "12345_p0" and {ext} like ".jpg".
+type PathTemplatesConfig struct {
+	PixivIllust string
+	// PixivIllustPages is used for illusts with multiple pages.
+	// PixivIllust is used if it is empty.
+	PixivIllustPages string
+}
+
+// Types of storage backends
+const (
+	BackendLocal  = "local"
+	BackendWebDAV = "webdav"
+	BackendS3     = "s3"
+)
+
+// BackendConfig defines the storage backend of a source.
+type BackendConfig struct {
+	// Type is one of "local", "webdav" and "s3".
+	Type string
+	// URL is the directory for local, the root URL of WebDAV,
+	// or the endpoint of S3 like "https://s3.example.com".
+	// The local directory of the source is used for local if it is empty.
+	URL string
+	// Username and Password are the access key ID and secret access key for S3.
+	Username string
+	Password string
+
+	Bucket string
+	Region string
+	// Prefix is prepended to the keys of S3 objects.
+	Prefix string
+	// PathStyle uses "endpoint/bucket/key" instead of "bucket.endpoint/key".
+	PathStyle bool
 }
 
 // ParsedPixiv returns the Storage.Pixiv if it is absolute path,
@@ -63,6 +116,12 @@ func (s *StorageConfig) ParsedPixiv() string {
 	return join(s.RootDir, s.Pixiv)
 }
 
+// ParsedDownloadJournal returns the Storage.DownloadJournal if it is absolute path,
+// otherwise it returns "Storage.RootDir/Storage.DownloadJournal".
+func (s *StorageConfig) ParsedDownloadJournal() string {
+	return join(s.RootDir, s.DownloadJournal)
+}
+
 // ServerConfig defines the Server field in Config.
 type ServerConfig struct {
 	Address string
@@ -81,6 +140,15 @@ type PixivConfig struct {
 	Language        string
 	APIProxy        string
 	DownloaderProxy string
+	// UgoiraFormat is the format ugoira are converted to:
+	// "webm" or "mp4" with ffmpeg, "gif" or "apng". Empty keeps the zip only.
+	UgoiraFormat string
+	// UgoiraFallbackFormat is used when UgoiraFormat needs ffmpeg but it is not found.
+	// Empty keeps the zip only then.
+	UgoiraFallbackFormat string
+	// Filter is the filter expression applied to every crawl command,
+	// like `!(user in [11, 12]) && !tag("R-18G")` for blocklists.
+	Filter string
 }
 
 // NetworkConfig defines the Network field in Config.
@@ -88,6 +156,63 @@ type NetworkConfig struct {
 	GlobalProxy string
 }
 
+// DownloaderConfig defines the Downloader field in Config.
+type DownloaderConfig struct {
+	// RateLimit is the global download speed limit like "2 MB" per second.
+	// Empty or "0" means unlimited.
+	RateLimit string
+	// HostRateLimits maps the host like "i.pximg.net" to its speed limit.
+	HostRateLimits map[string]string
+	// IdleTimeout like "60s" is the time to wait for bytes before reconnecting.
+	IdleTimeout string
+	// TaskTimeout like "10m" limits the time of a task, including reconnections.
+	// When it is exceeded, the task reconnects with the downloaded part as another try.
+	TaskTimeout string
+}
+
+// ParsedIdleTimeout returns the IdleTimeout as time.Duration.
+func (c *DownloaderConfig) ParsedIdleTimeout() (time.Duration, error) {
+	return parseDuration(c.IdleTimeout)
+}
+
+// ParsedTaskTimeout returns the TaskTimeout as time.Duration.
+func (c *DownloaderConfig) ParsedTaskTimeout() (time.Duration, error) {
+	return parseDuration(c.TaskTimeout)
+}
+
+func parseDuration(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// ParsedRateLimit returns the RateLimit in bytes.
+func (c *DownloaderConfig) ParsedRateLimit() (int64, error) {
+	return parseBytes(c.RateLimit)
+}
+
+// ParsedHostRateLimits returns the HostRateLimits in bytes.
+func (c *DownloaderConfig) ParsedHostRateLimits() (map[string]int64, error) {
+	m := make(map[string]int64, len(c.HostRateLimits))
+	for h, s := range c.HostRateLimits {
+		b, err := parseBytes(s)
+		if err != nil {
+			return nil, fmt.Errorf("rate limit of %s: %w", h, err)
+		}
+		m[h] = b
+	}
+	return m, nil
+}
+
+func parseBytes(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	b, err := humanize.ParseBytes(s)
+	return int64(b), err
+}
+
 // SystemConfig defines the System field in Config.
 type SystemConfig struct {
 	FFmpegCommand string
@@ -142,8 +267,14 @@ func New() *Config {
 			Address: "127.0.0.1:10233",
 		},
 		Storage: StorageConfig{
-			RootDir: defaultRoot,
-			Pixiv:   "pixiv",
+			RootDir:         defaultRoot,
+			Pixiv:           "pixiv",
+			DownloadJournal: "downloads/journal.jsonl",
+			Backends:        map[string]BackendConfig{},
+			PathTemplates: PathTemplatesConfig{
+				PixivIllust:      "{user.id}/{file}_{date}{ext}",
+				PixivIllustPages: "{user.id}/{illust.id}_{date}/{file}{ext}",
+			},
 		},
 		Database: DatabaseConfig{
 			// MongoURI referennce: https://docs.mongodb.com/manual/reference/connection-string/
@@ -151,10 +282,17 @@ func New() *Config {
 			DatabaseName: "bowerbird",
 		},
 		Pixiv: PixivConfig{
-			Language: "en",
+			Language:             "en",
+			UgoiraFormat:         "webm",
+			UgoiraFallbackFormat: "gif",
 		},
 		System: SystemConfig{
 			FFmpegCommand: "ffmpeg",
 		},
+		Downloader: DownloaderConfig{
+			HostRateLimits: map[string]string{},
+			IdleTimeout:    "60s",
+			TaskTimeout:    "30m",
+		},
 	}
 }
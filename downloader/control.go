@@ -0,0 +1,203 @@
+package downloader
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+)
+
+var taskStateNames = [...]string{
+	Pending:  "pending",
+	Running:  "running",
+	Finished: "finished",
+	Paused:   "paused",
+	Canceled: "canceled",
+	Failed:   "failed",
+	Skipped:  "skipped",
+}
+
+func (s taskState) String() string {
+	if s >= 0 && int(s) < len(taskStateNames) {
+		return taskStateNames[s]
+	}
+	return fmt.Sprintf("taskState(%d)", int(s))
+}
+
+// stopped is called when the context of the running task is done.
+// The .part file is kept for paused tasks and removed for canceled tasks.
+func (d *Downloader) stopped(t *Task, f *os.File, part string) {
+	d.mu.Lock()
+	s := t.stopAs
+	d.mu.Unlock()
+
+	switch s {
+	case Paused:
+		d.Logger.Info(fmt.Sprintf("Task paused: %q", t.LocalPath))
+		d.setStatus(t, Paused)
+	case Canceled:
+		f.Close()
+		if err := os.Remove(part); err != nil && !os.IsNotExist(err) {
+			d.Logger.Warn("Removing file of canceled task:", err)
+		}
+		removePartMeta(part)
+		d.Logger.Info(fmt.Sprintf("Task canceled: %q", t.LocalPath))
+		d.setStatus(t, Canceled)
+	default:
+		d.Logger.Debug("Task canceled by context:", t.Request.Context().Err())
+		d.setStatus(t, Canceled)
+	}
+}
+
+// TaskList returns a copy of d.Tasks.
+func (d *Downloader) TaskList() []*Task {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ts := make([]*Task, len(d.Tasks))
+	copy(ts, d.Tasks)
+	return ts
+}
+
+// TaskSnapshot is the state of a Task at some time.
+type TaskSnapshot struct {
+	ID           int64
+	Group        string
+	URL          string
+	LocalPath    string
+	Status       taskState
+	BytesLastSec int64
+	Err          error
+}
+
+// TaskSnapshots returns the states of d.Tasks,
+// which are safe to read while the tasks are running.
+func (d *Downloader) TaskSnapshots() []TaskSnapshot {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ss := make([]TaskSnapshot, 0, len(d.Tasks))
+	for _, t := range d.Tasks {
+		ss = append(ss, TaskSnapshot{
+			ID:           t.ID,
+			Group:        t.Group,
+			URL:          t.Request.URL.String(),
+			LocalPath:    t.LocalPath,
+			Status:       t.Status,
+			BytesLastSec: atomic.LoadInt64(&t.BytesLastSec),
+			Err:          t.Err,
+		})
+	}
+	return ss
+}
+
+// GroupIDs returns the IDs of tasks in the group.
+func (d *Downloader) GroupIDs(group string) []int64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ids := []int64{}
+	for _, t := range d.Tasks {
+		if t.Group == group {
+			ids = append(ids, t.ID)
+		}
+	}
+	return ids
+}
+
+// eachTask calls fn with d.mu held for every task with the given IDs
+// and saves the tasks whose status are changed by fn to the journal.
+func (d *Downloader) eachTask(ids []int64, fn func(t *Task) bool) int {
+	set := make(map[int64]struct{}, len(ids))
+	for _, id := range ids {
+		set[id] = struct{}{}
+	}
+
+	changed := []*Task{}
+	n := 0
+	d.mu.Lock()
+	for _, t := range d.Tasks {
+		if _, ok := set[t.ID]; !ok {
+			continue
+		}
+		s := t.Status
+		if fn(t) {
+			n++
+			if t.Status != s {
+				changed = append(changed, t)
+			}
+		}
+	}
+	d.mu.Unlock()
+
+	for _, t := range changed {
+		d.setStatus(t, t.Status)
+	}
+	return n
+}
+
+// Pause pauses the pending and running tasks with the given IDs.
+// The downloaded part of the running tasks is kept,
+// and they will continue with a Range request when resumed.
+// It returns the number of paused tasks.
+func (d *Downloader) Pause(ids ...int64) int {
+	return d.eachTask(ids, func(t *Task) bool {
+		switch t.Status {
+		case Pending:
+			t.Status = Paused
+			return true
+		case Running:
+			if t.cancel != nil {
+				t.stopAs = Paused
+				t.cancel()
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// Resume pushes the paused and failed tasks with the given IDs
+// back to the queue. It returns the number of resumed tasks.
+func (d *Downloader) Resume(ids ...int64) int {
+	resumed := []*Task{}
+	n := d.eachTask(ids, func(t *Task) bool {
+		switch t.Status {
+		case Paused, Failed:
+			t.Status = Pending
+			t.Err = nil
+			resumed = append(resumed, t)
+			return true
+		}
+		return false
+	})
+	for _, t := range resumed {
+		d.enqueue(t)
+	}
+	return n
+}
+
+// Cancel cancels the pending, running and paused tasks with the given IDs
+// and removes their downloaded part. It returns the number of canceled tasks.
+func (d *Downloader) Cancel(ids ...int64) int {
+	removed := []*Task{}
+	n := d.eachTask(ids, func(t *Task) bool {
+		switch t.Status {
+		case Pending, Paused:
+			t.Status = Canceled
+			removed = append(removed, t)
+			return true
+		case Running:
+			if t.cancel != nil {
+				t.stopAs = Canceled
+				t.cancel()
+				return true
+			}
+		}
+		return false
+	})
+	for _, t := range removed {
+		part := t.LocalPath + ".part"
+		if err := os.Remove(part); err != nil && !os.IsNotExist(err) {
+			d.Logger.Warn("Removing file of canceled task:", err)
+		}
+		removePartMeta(part)
+	}
+	return n
+}
@@ -0,0 +1,128 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/WOo0W/bowerbird/cli/log"
+	"github.com/WOo0W/bowerbird/storage"
+)
+
+func TestPauseResumeCancel(t *testing.T) {
+	content := bytes.Repeat([]byte("bowerbird"), 1024)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer ts.Close()
+
+	ctx := log.NewContext(context.Background(), log.New())
+	d := NewWithCliet(ctx, ts.Client())
+	dir := t.TempDir()
+
+	newTask := func(name string) *Task {
+		req, err := http.NewRequest("GET", ts.URL+"/"+name, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &Task{Request: req, LocalPath: filepath.Join(dir, name), Group: "g", NoImageChecking: true}
+	}
+	t1, t2 := newTask("1.bin"), newTask("2.bin")
+	d.Add(t1)
+	d.Add(t2)
+	if n := d.Pause(d.GroupIDs("g")...); n != 2 {
+		t.Fatalf("expected 2 tasks paused, got %d", n)
+	}
+	// a part file left by the paused task
+	ioutil.WriteFile(t1.LocalPath+".part", content[:100], 0644)
+	if n := d.Cancel(t2.ID); n != 1 {
+		t.Fatalf("expected 1 task canceled, got %d", n)
+	}
+
+	d.Start()
+	defer d.Stop()
+	d.Wait()
+	if t1.Status != Paused || t2.Status != Canceled {
+		t.Fatalf("unexpected status %s %s", t1.Status, t2.Status)
+	}
+
+	if n := d.Resume(t1.ID, t2.ID); n != 1 {
+		t.Fatalf("expected 1 task resumed, got %d", n)
+	}
+	d.Wait()
+	if t1.Status != Finished {
+		t.Fatalf("unexpected status %s: %v", t1.Status, t1.Err)
+	}
+	b, err := ioutil.ReadFile(t1.LocalPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, content) {
+		t.Error("content of the resumed task doesn't match")
+	}
+	if _, err := os.Stat(t2.LocalPath); !os.IsNotExist(err) {
+		t.Error("canceled task should not be downloaded")
+	}
+
+	ss := d.TaskSnapshots()
+	if len(ss) != 2 || ss[0].ID != t1.ID || ss[0].Status != Finished || ss[1].Status != Canceled {
+		t.Errorf("unexpected snapshots %+v", ss)
+	}
+	if ss[0].URL != ts.URL+"/1.bin" {
+		t.Errorf("unexpected URL %q", ss[0].URL)
+	}
+}
+
+// blockingStat blocks Stat until stat is received twice,
+// to hold the worker while it is taking a task.
+type blockingStat struct {
+	storage.Storage
+	stat chan struct{}
+}
+
+func (s *blockingStat) Stat(ctx context.Context, name string) (*storage.FileInfo, error) {
+	s.stat <- struct{}{}
+	<-s.stat
+	return s.Storage.Stat(ctx, name)
+}
+
+func TestPauseWhileDequeued(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("bowerbird"))
+	}))
+	defer ts.Close()
+
+	ctx := log.NewContext(context.Background(), log.New())
+	d := NewWithCliet(ctx, ts.Client())
+	s := &blockingStat{storage.NewLocal(t.TempDir()), make(chan struct{})}
+	d.Storage = s
+	d.StagingDir = t.TempDir()
+	d.Start()
+	defer d.Stop()
+
+	for _, stop := range []func(ids ...int64) int{d.Pause, d.Cancel} {
+		req, err := http.NewRequest("GET", ts.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		task := &Task{Request: req, LocalPath: filepath.Join(d.StagingDir, "1.bin"), NoImageChecking: true}
+		d.Add(task)
+		// the worker has taken the task and is checking the file
+		<-s.stat
+		if n := stop(task.ID); n != 1 {
+			t.Fatalf("expected 1 task stopped, got %d", n)
+		}
+		want := task.Status
+		s.stat <- struct{}{}
+		d.Wait()
+		if task.Status != want {
+			t.Fatalf("task stopped as %s ends with status %s", want, task.Status)
+		}
+	}
+}
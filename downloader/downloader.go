@@ -2,6 +2,9 @@ package downloader
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"image"
 	"strings"
@@ -20,11 +23,13 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/WOo0W/bowerbird/cli/log"
 
 	"github.com/WOo0W/bowerbird/helper"
+	"github.com/WOo0W/bowerbird/storage"
 )
 
 type taskState int
@@ -44,6 +49,7 @@ const (
 	defaultRetryMax     = 30
 	defaultRetryWaitMin = 1 * time.Second
 	defaultRetryWaitMax = 60 * time.Second
+	defaultIdleTimeout  = 60 * time.Second
 )
 
 // Backoff calculates time to wait for the next retry.
@@ -53,6 +59,18 @@ type Backoff func(min, max time.Duration, attemptNum int, resp *http.Response) t
 type Task struct {
 	bytesNow int64 // bytesNow saves the downloaded bytes in this second.
 
+	// ID is set by Downloader.Add if it is zero.
+	ID int64
+	// Group is used to control tasks of the same work together.
+	Group string
+
+	// queued is true if the task is in the queue of Downloader.
+	queued bool
+	// cancel stops the running task.
+	cancel context.CancelFunc
+	// stopAs is the state to set after the running task stopped by cancel.
+	stopAs taskState
+
 	BytesLastSec    int64
 	Err             error
 	Status          taskState
@@ -61,23 +79,46 @@ type Task struct {
 	Overwrite       bool
 	NoImageChecking bool
 
+	// SHA256, Size and MIME are set when the file is downloaded.
+	// They are empty if the file exists before downloading.
+	SHA256 string
+	Size   int64
+	MIME   string
+
 	AfterFinished func(*Task)
+	// AfterFailed is called after the task failed with t.Err.
+	AfterFailed func(*Task)
 }
 
-func (t *Task) copy(dst io.Writer, src io.Reader, bytesChan chan int64) (written int64, err error) {
+func (t *Task) copy(ctx context.Context, dst io.Writer, src io.Reader, bytesChan chan int64, limiters ...*Limiter) (written int64, err error) {
 	// set the t.bytesNow to t.BytesLastSec and clear it every second
 	bytesTicker := time.NewTicker(1 * time.Second)
 	defer func() {
 		bytesTicker.Stop()
-		t.BytesLastSec = 0
+		atomic.StoreInt64(&t.BytesLastSec, 0)
 		t.bytesNow = 0
 	}()
 	buf := make([]byte, 32*1024)
 
 	for {
-		nr, er := src.Read(buf)
+		size := len(buf)
+		for _, l := range limiters {
+			// read smaller chunks with low rate limits to keep the speed smooth
+			if r := int(l.Rate() / 4); r > 0 && r < size {
+				size = r
+			}
+		}
+		if size < 512 {
+			size = 512
+		}
+		nr, er := src.Read(buf[:size])
 
 		if nr > 0 {
+			for _, l := range limiters {
+				if err = l.WaitN(ctx, nr); err != nil {
+					return written, err
+				}
+			}
 			nw, ew := dst.Write(buf[0:nr])
 			if nw > 0 {
 				n := int64(nw)
@@ -85,7 +126,7 @@ func (t *Task) copy(dst io.Writer, src io.Reader, bytesChan chan int64) (written
 
 				select {
 				case <-bytesTicker.C:
-					t.BytesLastSec = t.bytesNow
+					atomic.StoreInt64(&t.BytesLastSec, t.bytesNow)
 					t.bytesNow = 0
 				default:
 					t.bytesNow += n
@@ -112,6 +153,74 @@ func (t *Task) copy(dst io.Writer, src io.Reader, bytesChan chan int64) (written
 	return written, err
 }
 
+// storageName returns the name of the file of t in d.Storage.
+func (d *Downloader) storageName(t *Task) (string, error) {
+	rel, err := filepath.Rel(d.StagingDir, t.LocalPath)
+	if err != nil {
+		return "", err
+	}
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%q is not in the staging directory %q", t.LocalPath, d.StagingDir)
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// notExist reports whether the file of t is not downloaded yet.
+func (d *Downloader) notExist(ctx context.Context, t *Task) bool {
+	if d.Storage == nil {
+		_, err := os.Stat(t.LocalPath)
+		return os.IsNotExist(err)
+	}
+	name, err := d.storageName(t)
+	if err != nil {
+		return true
+	}
+	_, err = d.Storage.Stat(ctx, name)
+	if err != nil && !errors.Is(err, storage.ErrNotExist) {
+		d.Logger.Warn(fmt.Sprintf("Stating %q in storage: %s", name, err))
+	}
+	return err != nil
+}
+
+// store moves the finished part file to t.LocalPath, or into d.Storage.
+func (d *Downloader) store(ctx context.Context, t *Task, part string) error {
+	if d.Storage == nil {
+		return os.Rename(part, t.LocalPath)
+	}
+	name, err := d.storageName(t)
+	if err != nil {
+		return err
+	}
+	if m, ok := d.Storage.(storage.Mover); ok {
+		return m.Move(ctx, part, name)
+	}
+
+	f, err := os.Open(part)
+	if err != nil {
+		return err
+	}
+	err = d.Storage.Put(ctx, name, f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	return os.Remove(part)
+}
+
+// CheckImage decodes the image from r to check if it is complete.
+// It returns image.ErrFormat if the format is not registered.
+func CheckImage(r io.Reader) error {
+	_, _, err := image.Decode(r)
+	return err
+}
+
+// detectMIME detects the content type with the first 512 bytes of f.
+func detectMIME(f *os.File) string {
+	b := make([]byte, 512)
+	n, _ := f.ReadAt(b, 0)
+	return http.DetectContentType(b[:n])
+}
+
 // Downloader processes the added tasks and save them to disk.
 type Downloader struct {
 	runningWorkers    int
@@ -123,8 +232,22 @@ type Downloader struct {
 	BytesLastSec int64
 	Logger *log.Logger
 
-	in    chan *Task
-	Tasks []*Task
+	in     chan *Task
+	Tasks  []*Task
+	mu     sync.Mutex
+	lastID int64
+
+	// Journal saves the unfinished tasks to disk if not nil.
+	Journal *Journal
+
+	// Storage stores the finished files if not nil.
+	// The files are downloaded to LocalPath first, then put into Storage
+	// with the path relative to StagingDir as the name.
+	Storage    storage.Storage
+	StagingDir string
+
+	limiter      *Limiter
+	hostLimiters map[string]*Limiter
 
 	wg           sync.WaitGroup
 	once         sync.Once
@@ -134,6 +257,14 @@ type Downloader struct {
 	RetryWaitMax time.Duration
 	Backoff      Backoff
 	MaxWorkers   int
+
+	// IdleTimeout is the time to wait for bytes before reconnecting.
+	IdleTimeout time.Duration
+	// TaskTimeout limits the time of a task, including reconnections.
+	// When it is exceeded, the task reconnects with the downloaded part
+	// as another try with a new TaskTimeout, and fails with ErrTaskTimeout
+	// after TriesMax.
+	TaskTimeout time.Duration
 }
 
 func (d *Downloader) worker() {
@@ -142,6 +273,9 @@ func (d *Downloader) worker() {
 		case <-d.stopAll:
 			return
 		case t := <-d.in:
+			d.mu.Lock()
+			t.queued = false
+			d.mu.Unlock()
 			d.Download(t)
 			d.wg.Done()
 			// d.out <- t
@@ -187,13 +321,71 @@ func (d *Downloader) Stop() {
 
 // Add pushes the task to the downloader queue.
 func (d *Downloader) Add(task *Task) {
+	d.mu.Lock()
+	if d.Journal != nil {
+		if id := d.Journal.MaxID(); id > d.lastID {
+			d.lastID = id
+		}
+	}
+	if task.ID == 0 {
+		d.lastID++
+		task.ID = d.lastID
+	} else if task.ID > d.lastID {
+		d.lastID = task.ID
+	}
 	d.Tasks = append(d.Tasks, task)
+	d.mu.Unlock()
+
+	d.setStatus(task, Pending)
+	d.enqueue(task)
+}
+
+// enqueue pushes the task to d.in.
+func (d *Downloader) enqueue(task *Task) {
+	d.mu.Lock()
+	if task.queued {
+		d.mu.Unlock()
+		return
+	}
+	task.queued = true
+	d.mu.Unlock()
+
 	d.wg.Add(1)
 	go func() {
 		d.in <- task
 	}()
 }
 
+// setStatus sets the status of t and saves it to the journal.
+func (d *Downloader) setStatus(t *Task, s taskState) {
+	d.mu.Lock()
+	t.Status = s
+	d.mu.Unlock()
+	if d.Journal != nil {
+		if err := d.Journal.Save(t); err != nil {
+			d.Logger.Error(fmt.Sprintf("Saving task %q to journal: %s", t.LocalPath, err))
+		}
+	}
+}
+
+// setStatusFrom sets the status of t to s and saves it to the journal
+// only if the status is from. It reports whether the status is set.
+func (d *Downloader) setStatusFrom(t *Task, from, s taskState) bool {
+	d.mu.Lock()
+	if t.Status != from {
+		d.mu.Unlock()
+		return false
+	}
+	t.Status = s
+	d.mu.Unlock()
+	if d.Journal != nil {
+		if err := d.Journal.Save(t); err != nil {
+			d.Logger.Error(fmt.Sprintf("Saving task %q to journal: %s", t.LocalPath, err))
+		}
+	}
+	return true
+}
+
 // Wait blocks until all tasks are done.
 func (d *Downloader) Wait() {
 	d.wg.Wait()
@@ -213,35 +405,60 @@ func NewWithCliet(ctx context.Context, c *http.Client) *Downloader {
 		bytesChan:    make(chan int64),
 		stopAll:      make(chan struct{}),
 		Tasks:        []*Task{},
+		limiter:      &Limiter{},
+		hostLimiters: make(map[string]*Limiter),
 		MaxWorkers:   2,
+		IdleTimeout:  defaultIdleTimeout,
 	}
 }
 
 // Download starts downloading the given task.
 func (d *Downloader) Download(t *Task) {
+	ctx, cancel := context.WithCancel(t.Request.Context())
+	defer cancel()
+
+	d.mu.Lock()
+	if t.Status == Paused || t.Status == Canceled {
+		// paused or canceled before started
+		d.mu.Unlock()
+		return
+	}
+	t.cancel = cancel
+	t.stopAs = Pending
+	d.mu.Unlock()
+
 	if !t.Overwrite {
-		if _, err := os.Stat(t.LocalPath); !os.IsNotExist(err) {
-			t.Status = Finished
-			if t.AfterFinished != nil {
+		if !d.notExist(ctx, t) {
+			if d.setStatusFrom(t, Pending, Finished) && t.AfterFinished != nil {
 				t.AfterFinished(t)
 			}
 			return
 		}
 	}
 
-	t.Status = Running
+	if !d.setStatusFrom(t, Pending, Running) {
+		// paused or canceled while starting
+		return
+	}
 
-	ctx := t.Request.Context()
 	req := t.Request.Clone(ctx)
 	var tries int
 	var bytes int64
+	// lastResp is the failed response passed to Backoff
+	var lastResp *http.Response
+	var lastErr error
 	part := t.LocalPath + ".part"
 	fn := filepath.Base(t.LocalPath)
 
 	onErr := func(message string, err error) {
 		d.Logger.Error(fmt.Sprintf("Task failed: Download %q to %q: %s: %s", t.Request.URL, t.LocalPath, message, err))
-		t.Status = Failed
+		d.mu.Lock()
 		t.Err = err
+		d.mu.Unlock()
+		d.setStatus(t, Failed)
+		if t.AfterFailed != nil {
+			t.AfterFailed(t)
+		}
 	}
 
 	d.Logger.Debug(fmt.Sprintf("Starting task %q -> %s", req.URL, t.LocalPath))
@@ -262,78 +479,219 @@ func (d *Downloader) Download(t *Task) {
 		bytes = fi.Size()
 	}
 
+	hash := sha256.New()
 	if bytes > 0 {
-		// Write to the end of the file
+		// hash the downloaded part and write to the end of the file
+		if _, err := io.CopyN(hash, f, bytes); err != nil {
+			onErr("Reading file", err)
+			return
+		}
 		f.Seek(0, 2)
 	}
+	meta := loadPartMeta(part)
+	var mime string
+
+	// restart truncates the part file to download from the beginning
+	restart := func() error {
+		bytes = 0
+		meta = nil
+		hash.Reset()
+		if err := removePartMeta(part); err != nil {
+			return err
+		}
+		if err := f.Truncate(0); err != nil {
+			return err
+		}
+		_, err := f.Seek(0, 0)
+		return err
+	}
+
+	onFinished := func(size int64) {
+		t.SHA256 = hex.EncodeToString(hash.Sum(nil))
+		t.Size = size
+		t.MIME = mime
+		if t.MIME == "" || t.MIME == "application/octet-stream" {
+			t.MIME = detectMIME(f)
+		}
+		f.Close()
+		err := d.store(ctx, t, part)
+		if err != nil {
+			onErr("Storing file", err)
+			return
+		}
+		if err := removePartMeta(part); err != nil {
+			d.Logger.Warn("Removing meta file:", err)
+		}
+		d.setStatus(t, Finished)
+		d.Logger.Info(fmt.Sprintf("Task finished: %q Size: %d", fn, size))
+		if t.AfterFinished != nil {
+			// call AfterFinished hook
+			t.AfterFinished(t)
+		}
+	}
+
+	// deadline is the end of the time of the task until it is exceeded
+	var deadline time.Time
+	if d.TaskTimeout > 0 {
+		deadline = time.Now().Add(d.TaskTimeout)
+	}
+	// wd cancels the current connection if it stalls
+	var wd *watchdog
+	defer func() {
+		if wd != nil {
+			wd.stop()
+		}
+	}()
 
 	for {
+		if wd != nil {
+			wd.stop()
+		}
+
 		if bytes > 0 {
 			// skip the downloaded part
 			req.Header["Range"] = []string{fmt.Sprintf("bytes=%d-", bytes)}
+			if v := meta.ifRange(); v != "" {
+				// the server sends the full file if it has changed
+				req.Header["If-Range"] = []string{v}
+			} else {
+				delete(req.Header, "If-Range")
+			}
 			d.Logger.Debug("Trying again with header:", req.Header)
+		} else {
+			delete(req.Header, "Range")
+			delete(req.Header, "If-Range")
 		}
 
 		tries++
 		if tries > d.TriesMax {
-			onErr("Max tries", err)
+			onErr("Max tries", lastErr)
 			return
 		}
 		if tries > 1 {
 			select {
 			case <-ctx.Done():
-				d.Logger.Debug("Task canceled by context:", ctx.Err())
-				t.Status = Canceled
+				d.stopped(t, f, part)
 				return
-			case <-time.After(d.Backoff(d.RetryWaitMin, d.RetryWaitMax, tries, nil)):
+			case <-time.After(d.Backoff(d.RetryWaitMin, d.RetryWaitMax, tries, lastResp)):
 			}
 		}
+		lastResp = nil
+		if !deadline.IsZero() && !time.Now().Before(deadline) {
+			deadline = time.Now().Add(d.TaskTimeout)
+		}
 
-		resp, err := d.Client.Do(req)
+		var actx context.Context
+		actx, wd = newWatchdog(ctx, d.IdleTimeout, deadline)
+		resp, err := d.Client.Do(req.WithContext(actx))
 		if err != nil {
-			d.Logger.Warn(err)
+			if ctx.Err() != nil {
+				d.stopped(t, f, part)
+				return
+			}
+			if werr := wd.Err(); werr != nil {
+				d.Logger.Warn(fmt.Sprintf("Task %q: %s: Reconnecting", t.LocalPath, werr))
+				err = werr
+			} else {
+				d.Logger.Warn(err)
+			}
+			lastErr = err
+			continue
+		}
+
+		if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && bytes > 0 {
+			resp.Body.Close()
+			if _, _, total, ok := parseContentRange(resp.Header.Get("Content-Range")); ok && total == bytes {
+				// the part file has been completed
+				onFinished(bytes)
+				return
+			}
+			d.Logger.Warn(fmt.Sprintf("Range of %q not satisfiable with %d bytes downloaded: Restarting", t.LocalPath, bytes))
+			if err := restart(); err != nil {
+				onErr("Restarting", err)
+				return
+			}
+			lastErr = &StatusError{StatusCode: resp.StatusCode}
 			continue
 		}
 
 		if !(resp.StatusCode >= 200 && resp.StatusCode < 300) {
-			r, err := ioutil.ReadAll(resp.Body)
+			// read a limited size of the message
+			r, err := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
 			resp.Body.Close()
 			if err != nil {
 				err = fmt.Errorf("http code %d with reading error: %w", resp.StatusCode, err)
 			} else {
-				err = fmt.Errorf("http code %d with message: %s", resp.StatusCode, r)
+				err = &StatusError{StatusCode: resp.StatusCode, Message: string(r)}
 			}
 
+			if isTemporaryStatus(resp.StatusCode) {
+				d.Logger.Warn(fmt.Sprintf("Got HTTP %d from %q: Trying again", resp.StatusCode, req.URL))
+				lastResp = resp
+				lastErr = err
+				continue
+			}
 			onErr("Not HTTP OK", err)
 			return
 		}
 
+		if bytes > 0 {
+			if resp.StatusCode == http.StatusPartialContent {
+				if start, _, _, ok := parseContentRange(resp.Header.Get("Content-Range")); !ok || start != bytes {
+					resp.Body.Close()
+					d.Logger.Warn(fmt.Sprintf("Unexpected Content-Range %q of %q: Restarting", resp.Header.Get("Content-Range"), t.LocalPath))
+					if err := restart(); err != nil {
+						onErr("Restarting", err)
+						return
+					}
+					continue
+				}
+			} else {
+				// the full file is sent
+				d.Logger.Warn(fmt.Sprintf("Remote file of %q has changed or Range is not supported: Restarting", t.LocalPath))
+				if err := restart(); err != nil {
+					resp.Body.Close()
+					onErr("Restarting", err)
+					return
+				}
+			}
+		}
+		if bytes == 0 {
+			meta, err = savePartMeta(part, resp)
+			if err != nil {
+				d.Logger.Warn("Saving meta file:", err)
+			}
+		}
+		if ct := resp.Header.Get("Content-Type"); ct != "" {
+			mime = strings.TrimSpace(strings.SplitN(ct, ";", 2)[0])
+		}
+
 		if resp.ContentLength == -1 {
 			d.Logger.Warn(fmt.Sprintf("File %q started with Content-Length unknown: Request headers: %v Response headers: %v", t.LocalPath, req.Header, resp.Header))
 		}
 
-		written, err := t.copy(f, resp.Body, d.bytesChan)
+		written, err := t.copy(ctx, io.MultiWriter(f, hash), &watchdogReader{resp.Body, wd}, d.bytesChan, d.limiters(req.URL.Hostname())...)
 
-		onFinished := func() {
-			f.Close()
-			err := os.Rename(part, t.LocalPath)
-			if err != nil {
-				onErr("Renaming file", err)
-				return
-			}
-			t.Status = Finished
-			d.Logger.Info(fmt.Sprintf("Task finished: %q Size: %d", fn, written))
-			if t.AfterFinished != nil {
-				// call AfterFinished hook
-				t.AfterFinished(t)
-			}
+		resp.Body.Close()
+
+		if ctx.Err() != nil {
+			d.stopped(t, f, part)
 			return
 		}
 
-		resp.Body.Close()
+		if werr := wd.Err(); werr != nil {
+			if err := f.Sync(); err != nil {
+				onErr("Saving file", err)
+				return
+			}
+			d.Logger.Warn(fmt.Sprintf("Task %q: %s after %d bytes: Reconnecting with Range", t.LocalPath, werr, written))
+			lastErr = werr
+			bytes += written
+			continue
+		}
 
 		if written == resp.ContentLength {
-			onFinished()
+			onFinished(bytes + written)
 			return
 		} else if resp.ContentLength == -1 {
 			if !t.NoImageChecking && strings.HasPrefix(resp.Header.Get("Content-Type"), "image/") {
@@ -345,23 +703,27 @@ func (d *Downloader) Download(t *Task) {
 				}
 				_, _, err = image.Decode(f)
 				if err == nil {
-					onFinished()
+					onFinished(bytes + written)
 					return
 				}
-				_, err = f.Seek(0, 0)
-				if err != nil {
+				d.Logger.Warn(fmt.Sprintf("Broken image %q: Trying again", part))
+				if err := restart(); err != nil {
 					onErr("After checking image", err)
 					return
 				}
-				d.Logger.Warn(fmt.Sprintf("Broken image %q: Trying again", part))
-				bytes = 0
+				lastErr = err
+				continue
 			} else {
-				onFinished()
+				onFinished(bytes + written)
 				return
 			}
 		}
 
 		d.Logger.Warn(fmt.Sprintf("ContentLength doesn't match. Bytes Written: %d ContentLength: %d URL: %q File: %q, Request header: %v Response header: %v Error: %v", written, resp.ContentLength, req.URL, t.LocalPath, req.Header, resp.Header, err))
+		if err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+		lastErr = err
 		if err := f.Sync(); err != nil {
 			onErr("Saving file", err)
 			return
@@ -1,6 +1,19 @@
 package downloader
 
-import "testing"
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/WOo0W/bowerbird/cli/log"
+	"github.com/WOo0W/bowerbird/storage"
+	"golang.org/x/net/webdav"
+)
 
 func TestDownloader(t *testing.T) {
 	for i := 0; i < 10; i++ {
@@ -9,3 +22,104 @@ func TestDownloader(t *testing.T) {
 		}(i)
 	}
 }
+
+func TestDownloadStatus(t *testing.T) {
+	tries := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/busy":
+			tries++
+			if tries < 3 {
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.Write([]byte("ok"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	ctx := log.NewContext(context.Background(), log.New())
+	d := NewWithCliet(ctx, ts.Client())
+	d.RetryWaitMin = time.Millisecond
+	d.RetryWaitMax = 10 * time.Millisecond
+	d.Start()
+	defer d.Stop()
+	dir := t.TempDir()
+
+	newTask := func(name string) *Task {
+		req, err := http.NewRequest("GET", ts.URL+"/"+name, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &Task{Request: req, LocalPath: filepath.Join(dir, name), NoImageChecking: true}
+	}
+
+	busy, gone := newTask("busy"), newTask("gone")
+	failed := 0
+	gone.AfterFailed = func(*Task) { failed++ }
+	d.Add(busy)
+	d.Add(gone)
+	d.Wait()
+
+	if busy.Status != Finished || tries != 3 {
+		t.Errorf("unexpected status %s after %d tries: %v", busy.Status, tries, busy.Err)
+	}
+	if b, _ := ioutil.ReadFile(busy.LocalPath); string(b) != "ok" {
+		t.Errorf("unexpected content %q", b)
+	}
+	if gone.Status != Failed || !IsGone(gone.Err) {
+		t.Errorf("unexpected status %s: %v", gone.Status, gone.Err)
+	}
+	if failed != 1 {
+		t.Errorf("AfterFailed called %d times", failed)
+	}
+}
+
+func TestDownloadToStorage(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("content of " + r.URL.Path))
+	}))
+	defer ts.Close()
+	dav := httptest.NewServer(&webdav.Handler{
+		FileSystem: webdav.NewMemFS(),
+		LockSystem: webdav.NewMemLS(),
+	})
+	defer dav.Close()
+
+	ctx := log.NewContext(context.Background(), log.New())
+	d := NewWithCliet(ctx, ts.Client())
+	s, err := storage.NewWebDAV(dav.URL, "", "", dav.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Storage = s
+	d.StagingDir = t.TempDir()
+	d.Start()
+	defer d.Stop()
+
+	req, err := http.NewRequest("GET", ts.URL+"/1_p0.jpg", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	task := &Task{Request: req, LocalPath: filepath.Join(d.StagingDir, "1", "1_p0.jpg"), NoImageChecking: true}
+	d.Add(task)
+	d.Wait()
+
+	if task.Status != Finished {
+		t.Fatalf("unexpected status %s: %v", task.Status, task.Err)
+	}
+	r, err := s.Open(ctx, "1/1_p0.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if b, _ := ioutil.ReadAll(r); string(b) != "content of /1_p0.jpg" {
+		t.Errorf("unexpected content %q", b)
+	}
+	if _, err := os.Stat(task.LocalPath); !os.IsNotExist(err) {
+		t.Errorf("staged file is not removed: %v", err)
+	}
+}
@@ -0,0 +1,57 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Errors of connections canceled by the watchdog.
+var (
+	ErrIdleTimeout = errors.New("no bytes received within the idle timeout")
+	ErrTaskTimeout = errors.New("task lasts longer than the task timeout")
+)
+
+// StatusError is the error of a task which got an unexpected HTTP status code.
+type StatusError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("http code %d with message: %s", e.StatusCode, e.Message)
+}
+
+// Temporary reports whether the request may succeed if tried again later.
+func (e *StatusError) Temporary() bool {
+	return isTemporaryStatus(e.StatusCode)
+}
+
+// Gone reports whether the file is removed or forbidden on the server.
+func (e *StatusError) Gone() bool {
+	switch e.StatusCode {
+	case http.StatusNotFound, http.StatusForbidden, http.StatusGone:
+		return true
+	}
+	return false
+}
+
+func isTemporaryStatus(code int) bool {
+	switch code {
+	case http.StatusRequestTimeout,
+		http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// IsGone reports whether err is a *StatusError of a file
+// removed or forbidden on the server, like HTTP 404 and 403.
+func IsGone(err error) bool {
+	var se *StatusError
+	return errors.As(err, &se) && se.Gone()
+}
@@ -0,0 +1,291 @@
+package downloader
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// journalRecord is the serializable form of a Task.
+type journalRecord struct {
+	ID              int64       `json:"id"`
+	Group           string      `json:"group,omitempty"`
+	Method          string      `json:"method"`
+	URL             string      `json:"url"`
+	Header          http.Header `json:"header,omitempty"`
+	LocalPath       string      `json:"localPath"`
+	Overwrite       bool        `json:"overwrite,omitempty"`
+	NoImageChecking bool        `json:"noImageChecking,omitempty"`
+	Status          taskState   `json:"status"`
+	Err             string      `json:"err,omitempty"`
+	// Gone is true if the task failed with a file removed from the server.
+	Gone bool `json:"gone,omitempty"`
+}
+
+// Journal saves the unfinished tasks of Downloader to disk,
+// so they can be restored after the program exits unexpectedly.
+//
+// Each change of a task is appended to the file as a line of JSON.
+// The file is compacted when the journal is opened and
+// when it grows too large.
+type Journal struct {
+	path string
+	// lock is the lock file held while the journal is open,
+	// so it is not used by other processes at the same time.
+	lock *os.File
+
+	mu      sync.Mutex
+	f       *os.File
+	lines   int
+	maxID   int64
+	records map[int64]*journalRecord
+	// paths maps the LocalPath to the ID of the record
+	paths map[string]int64
+}
+
+// ErrJournalLocked is returned by OpenJournal if the journal
+// is opened by another process.
+var ErrJournalLocked = errors.New("download journal is used by another process")
+
+// OpenJournal loads the journal file from path, or creates it if not exists.
+// The journal is locked with the file path + ".lock" until closed.
+func OpenJournal(path string) (*Journal, error) {
+	j := &Journal{
+		path:    path,
+		records: make(map[int64]*journalRecord),
+		paths:   make(map[string]int64),
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	lock, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(lock); err != nil {
+		lock.Close()
+		return nil, fmt.Errorf("%w: %s", ErrJournalLocked, path)
+	}
+	j.lock = lock
+	if err := j.load(); err != nil {
+		lock.Close()
+		return nil, err
+	}
+	return j, nil
+}
+
+// load reads the records from the journal file and compacts it.
+func (j *Journal) load() error {
+	f, err := os.Open(j.path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		s := bufio.NewScanner(f)
+		s.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		for s.Scan() {
+			r := &journalRecord{}
+			// the last line may be broken if the program was killed while writing
+			if err := json.Unmarshal(s.Bytes(), r); err != nil {
+				continue
+			}
+			j.apply(r)
+		}
+		f.Close()
+		if err := s.Err(); err != nil {
+			return err
+		}
+	}
+
+	for _, r := range j.records {
+		// tasks running in the last run are not finished
+		if r.Status == Running {
+			r.Status = Pending
+		}
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := j.compact(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// apply puts r into the records, or deletes the record if r is done.
+func (j *Journal) apply(r *journalRecord) {
+	if r.ID > j.maxID {
+		j.maxID = r.ID
+	}
+	if old, ok := j.paths[r.LocalPath]; ok && old != r.ID {
+		delete(j.records, old)
+	}
+	switch {
+	case r.Status == Finished, r.Status == Skipped, r.Status == Canceled,
+		// it's no use trying again
+		r.Status == Failed && r.Gone:
+		delete(j.records, r.ID)
+		if j.paths[r.LocalPath] == r.ID {
+			delete(j.paths, r.LocalPath)
+		}
+	default:
+		j.records[r.ID] = r
+		j.paths[r.LocalPath] = r.ID
+	}
+}
+
+// compact rewrites the journal file with the records in memory.
+// The caller must hold j.mu.
+func (j *Journal) compact() error {
+	tmp := j.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, r := range j.sortedRecords() {
+		if err := enc.Encode(r); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	f.Close()
+
+	if j.f != nil {
+		j.f.Close()
+		j.f = nil
+	}
+	if err := os.Rename(tmp, j.path); err != nil {
+		return err
+	}
+	j.f, err = os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	j.lines = len(j.records)
+	return nil
+}
+
+func (j *Journal) sortedRecords() []*journalRecord {
+	rs := make([]*journalRecord, 0, len(j.records))
+	for _, r := range j.records {
+		rs = append(rs, r)
+	}
+	sort.Slice(rs, func(a, b int) bool { return rs[a].ID < rs[b].ID })
+	return rs
+}
+
+// Save writes the current state of t to the journal.
+func (j *Journal) Save(t *Task) error {
+	r := &journalRecord{
+		ID:              t.ID,
+		Group:           t.Group,
+		Method:          t.Request.Method,
+		URL:             t.Request.URL.String(),
+		Header:          t.Request.Header,
+		LocalPath:       t.LocalPath,
+		Overwrite:       t.Overwrite,
+		NoImageChecking: t.NoImageChecking,
+		Status:          t.Status,
+	}
+	if t.Err != nil {
+		r.Err = t.Err.Error()
+		r.Gone = IsGone(t.Err)
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.f == nil {
+		return os.ErrClosed
+	}
+	j.apply(r)
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if _, err := j.f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	j.lines++
+	if j.lines > 2*len(j.records)+1024 {
+		return j.compact()
+	}
+	return nil
+}
+
+// MaxID returns the largest task ID ever saved in the journal.
+func (j *Journal) MaxID() int64 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.maxID
+}
+
+// Tasks rebuilds the unfinished tasks saved in the journal, ordered by ID.
+// The AfterFinished hooks are not saved and should be set by the caller.
+func (j *Journal) Tasks() ([]*Task, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	rs := j.sortedRecords()
+	ts := make([]*Task, 0, len(rs))
+	for _, r := range rs {
+		req, err := http.NewRequest(r.Method, r.URL, nil)
+		if err != nil {
+			return nil, err
+		}
+		if r.Header != nil {
+			req.Header = r.Header
+		}
+		ts = append(ts, &Task{
+			ID:              r.ID,
+			Group:           r.Group,
+			Request:         req,
+			LocalPath:       r.LocalPath,
+			Overwrite:       r.Overwrite,
+			NoImageChecking: r.NoImageChecking,
+			Status:          Pending,
+		})
+	}
+	return ts, nil
+}
+
+// Len returns the number of unfinished tasks in the journal.
+func (j *Journal) Len() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return len(j.records)
+}
+
+// Close compacts and closes the journal file, and releases the lock.
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.f == nil {
+		return nil
+	}
+	err := j.compact()
+	if j.f != nil {
+		j.f.Close()
+		j.f = nil
+	}
+	if j.lock != nil {
+		j.lock.Close()
+		j.lock = nil
+	}
+	return err
+}
@@ -0,0 +1,85 @@
+package downloader
+
+import (
+	"errors"
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+func TestJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	j, err := OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newTask := func(id int64, u, lp string) *Task {
+		req, err := http.NewRequest("GET", u, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header["Referer"] = []string{"https://app-api.pixiv.net"}
+		return &Task{ID: id, Request: req, LocalPath: lp}
+	}
+	t1 := newTask(1, "https://i.pximg.net/1.jpg", "/tmp/1.jpg")
+	t2 := newTask(2, "https://i.pximg.net/2.jpg", "/tmp/2.jpg")
+	t3 := newTask(3, "https://i.pximg.net/3.jpg", "/tmp/3.jpg")
+	for _, x := range []*Task{t1, t2, t3} {
+		if err := j.Save(x); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t1.Status = Running
+	t2.Status = Finished
+	j.Save(t1)
+	j.Save(t2)
+
+	// the same LocalPath replaces the old task
+	t4 := newTask(4, "https://i.pximg.net/3.jpg", "/tmp/3.jpg")
+	j.Save(t4)
+
+	// simulate a killed process by not closing j,
+	// whose lock is released by the system
+	j.lock.Close()
+	j2, err := OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j2.Close()
+	ts, err := j2.Tasks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ts) != 2 {
+		t.Fatalf("expected 2 tasks, got %d", len(ts))
+	}
+	if ts[0].ID != 1 || ts[0].Status != Pending || ts[0].Request.Header.Get("Referer") == "" {
+		t.Errorf("unexpected task %+v", ts[0])
+	}
+	if ts[1].ID != 4 || ts[1].LocalPath != "/tmp/3.jpg" {
+		t.Errorf("unexpected task %+v", ts[1])
+	}
+	if j2.MaxID() != 4 {
+		t.Errorf("expected max ID 4, got %d", j2.MaxID())
+	}
+}
+
+func TestJournalLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	j, err := OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenJournal(path); !errors.Is(err, ErrJournalLocked) {
+		t.Fatalf("expected ErrJournalLocked, got %v", err)
+	}
+	if err := j.Close(); err != nil {
+		t.Fatal(err)
+	}
+	j2, err := OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	j2.Close()
+}
@@ -0,0 +1,147 @@
+package downloader
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Limiter limits the bytes per second with a token bucket.
+// The zero value of Limiter is unlimited.
+type Limiter struct {
+	mu     sync.Mutex
+	rate   int64
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter returns a Limiter with the rate of bytesPerSec.
+func NewLimiter(bytesPerSec int64) *Limiter {
+	l := &Limiter{}
+	l.SetRate(bytesPerSec)
+	return l
+}
+
+// SetRate sets the rate of the limiter. 0 means unlimited.
+func (l *Limiter) SetRate(bytesPerSec int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if bytesPerSec < 0 {
+		bytesPerSec = 0
+	}
+	l.rate = bytesPerSec
+	l.tokens = 0
+	l.last = time.Now()
+}
+
+// Rate returns the rate of the limiter.
+func (l *Limiter) Rate() int64 {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.rate
+}
+
+// reserve takes n tokens from the bucket
+// and returns the time to wait before using them.
+func (l *Limiter) reserve(n int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate == 0 {
+		return 0
+	}
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * float64(l.rate)
+	// allow bursts of one second
+	if burst := float64(l.rate); l.tokens > burst {
+		l.tokens = burst
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / float64(l.rate) * float64(time.Second))
+}
+
+// WaitN blocks until n bytes are allowed or ctx is done.
+func (l *Limiter) WaitN(ctx context.Context, n int) error {
+	if l == nil {
+		return nil
+	}
+	wait := l.reserve(n)
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// SetRateLimit sets the global download speed limit
+// in bytes per second. 0 means unlimited.
+func (d *Downloader) SetRateLimit(bytesPerSec int64) {
+	d.limiter.SetRate(bytesPerSec)
+}
+
+// RateLimit returns the global download speed limit.
+func (d *Downloader) RateLimit() int64 {
+	return d.limiter.Rate()
+}
+
+// SetHostRateLimit sets the download speed limit of the host
+// in bytes per second. 0 removes the limit.
+func (d *Downloader) SetHostRateLimit(host string, bytesPerSec int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if bytesPerSec <= 0 {
+		delete(d.hostLimiters, host)
+		return
+	}
+	if l, ok := d.hostLimiters[host]; ok {
+		l.SetRate(bytesPerSec)
+		return
+	}
+	d.hostLimiters[host] = NewLimiter(bytesPerSec)
+}
+
+// SetRateLimits sets the global and per host download speed limits.
+// The limits of hosts not in hosts are removed.
+func (d *Downloader) SetRateLimits(global int64, hosts map[string]int64) {
+	d.SetRateLimit(global)
+	for h := range d.HostRateLimits() {
+		if _, ok := hosts[h]; !ok {
+			d.SetHostRateLimit(h, 0)
+		}
+	}
+	for h, b := range hosts {
+		d.SetHostRateLimit(h, b)
+	}
+}
+
+// HostRateLimits returns the download speed limits of hosts.
+func (d *Downloader) HostRateLimits() map[string]int64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	m := make(map[string]int64, len(d.hostLimiters))
+	for h, l := range d.hostLimiters {
+		m[h] = l.Rate()
+	}
+	return m
+}
+
+func (d *Downloader) limiters(host string) []*Limiter {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if l, ok := d.hostLimiters[host]; ok {
+		return []*Limiter{d.limiter, l}
+	}
+	return []*Limiter{d.limiter}
+}
@@ -0,0 +1,37 @@
+package downloader
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	ctx := context.Background()
+	l := NewLimiter(100 * 1024)
+
+	start := time.Now()
+	// 50 KiB over the empty bucket should take about 0.5s
+	for i := 0; i < 50; i++ {
+		if err := l.WaitN(ctx, 1024); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if e := time.Since(start); e < 400*time.Millisecond || e > 2*time.Second {
+		t.Errorf("unexpected elapsed time %s", e)
+	}
+
+	l.SetRate(0)
+	start = time.Now()
+	l.WaitN(ctx, 1<<30)
+	if e := time.Since(start); e > 100*time.Millisecond {
+		t.Errorf("unlimited limiter blocked for %s", e)
+	}
+
+	l.SetRate(1)
+	ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if err := l.WaitN(ctx, 1024); err == nil {
+		t.Error("expected error from canceled context")
+	}
+}
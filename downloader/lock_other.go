@@ -0,0 +1,11 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !windows
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!windows
+
+package downloader
+
+import "os"
+
+// lockFile does nothing on the systems without file locks.
+func lockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,108 @@
+package downloader
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// partMeta saves the validators of the remote file of a .part file,
+// so that the download can be resumed with If-Range safely.
+type partMeta struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+}
+
+func partMetaPath(part string) string {
+	return part + ".json"
+}
+
+// loadPartMeta returns nil if the meta file doesn't exist or is broken.
+func loadPartMeta(part string) *partMeta {
+	b, err := ioutil.ReadFile(partMetaPath(part))
+	if err != nil {
+		return nil
+	}
+	m := &partMeta{}
+	if err := json.Unmarshal(b, m); err != nil {
+		return nil
+	}
+	return m
+}
+
+// savePartMeta saves the validators in the headers of resp.
+func savePartMeta(part string, resp *http.Response) (*partMeta, error) {
+	m := &partMeta{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+	if m.ETag == "" && m.LastModified == "" {
+		return nil, removePartMeta(part)
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return m, ioutil.WriteFile(partMetaPath(part), b, 0644)
+}
+
+func removePartMeta(part string) error {
+	err := os.Remove(partMetaPath(part))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// ifRange returns the value of If-Range header.
+// Weak ETags can't be used in If-Range, so Last-Modified is used instead.
+func (m *partMeta) ifRange() string {
+	if m == nil {
+		return ""
+	}
+	if m.ETag != "" && !strings.HasPrefix(m.ETag, "W/") {
+		return m.ETag
+	}
+	return m.LastModified
+}
+
+// parseContentRange parses Content-Range header like
+// "bytes 100-199/200" or "bytes */200".
+// start and end are -1 for the unsatisfied range,
+// and total is -1 if the complete length is unknown.
+func parseContentRange(s string) (start, end, total int64, ok bool) {
+	if !strings.HasPrefix(s, "bytes ") {
+		return 0, 0, 0, false
+	}
+	s = strings.TrimSpace(s[len("bytes "):])
+	i := strings.IndexByte(s, '/')
+	if i < 0 {
+		return 0, 0, 0, false
+	}
+	rs, ts := s[:i], s[i+1:]
+
+	var err error
+	if ts == "*" {
+		total = -1
+	} else if total, err = strconv.ParseInt(ts, 10, 64); err != nil {
+		return 0, 0, 0, false
+	}
+
+	if rs == "*" {
+		return -1, -1, total, true
+	}
+	j := strings.IndexByte(rs, '-')
+	if j < 0 {
+		return 0, 0, 0, false
+	}
+	if start, err = strconv.ParseInt(rs[:j], 10, 64); err != nil {
+		return 0, 0, 0, false
+	}
+	if end, err = strconv.ParseInt(rs[j+1:], 10, 64); err != nil {
+		return 0, 0, 0, false
+	}
+	return start, end, total, true
+}
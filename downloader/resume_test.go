@@ -0,0 +1,99 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/WOo0W/bowerbird/cli/log"
+)
+
+func TestParseContentRange(t *testing.T) {
+	for _, c := range []struct {
+		s                 string
+		start, end, total int64
+		ok                bool
+	}{
+		{"bytes 100-199/200", 100, 199, 200, true},
+		{"bytes 0-0/*", 0, 0, -1, true},
+		{"bytes */200", -1, -1, 200, true},
+		{"bytes 100-199", 0, 0, 0, false},
+		{"items 1-2/3", 0, 0, 0, false},
+	} {
+		start, end, total, ok := parseContentRange(c.s)
+		if start != c.start || end != c.end || total != c.total || ok != c.ok {
+			t.Errorf("parseContentRange(%q) = %d %d %d %v", c.s, start, end, total, ok)
+		}
+	}
+}
+
+func TestResume(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	etag := `"v2"`
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", etag)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer ts.Close()
+
+	ctx := log.NewContext(context.Background(), log.New())
+	d := NewWithCliet(ctx, ts.Client())
+	d.RetryWaitMin = time.Millisecond
+	d.RetryWaitMax = 10 * time.Millisecond
+	d.Start()
+	defer d.Stop()
+	dir := t.TempDir()
+
+	cases := []struct {
+		name string
+		part []byte
+		etag string
+	}{
+		// resumes with 206
+		{"same", content[:100], etag},
+		// the remote file has changed, restarts with 200
+		{"changed", []byte("broken"), `"v1"`},
+		// the part file is complete, got 416
+		{"complete", content, etag},
+	}
+	tasks := []*Task{}
+	for i, c := range cases {
+		lp := filepath.Join(dir, strconv.Itoa(i))
+		ioutil.WriteFile(lp+".part", c.part, 0644)
+		ioutil.WriteFile(partMetaPath(lp+".part"), []byte(`{"etag":`+strconv.Quote(c.etag)+`}`), 0644)
+		req, err := http.NewRequest("GET", ts.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		task := &Task{Request: req, LocalPath: lp, NoImageChecking: true}
+		tasks = append(tasks, task)
+		d.Add(task)
+	}
+	d.Wait()
+
+	for i, task := range tasks {
+		if task.Status != Finished {
+			t.Errorf("%s: unexpected status %s: %v", cases[i].name, task.Status, task.Err)
+			continue
+		}
+		b, _ := ioutil.ReadFile(task.LocalPath)
+		if !bytes.Equal(b, content) {
+			t.Errorf("%s: content doesn't match", cases[i].name)
+		}
+		if sum := sha256.Sum256(content); task.SHA256 != hex.EncodeToString(sum[:]) {
+			t.Errorf("%s: unexpected hash %s", cases[i].name, task.SHA256)
+		}
+		if _, err := os.Stat(partMetaPath(task.LocalPath + ".part")); !os.IsNotExist(err) {
+			t.Errorf("%s: meta file is not removed", cases[i].name)
+		}
+	}
+}
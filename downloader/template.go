@@ -0,0 +1,69 @@
+package downloader
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/WOo0W/bowerbird/helper"
+)
+
+// PathTemplate builds the paths of files with placeholders like "{illust.id}".
+// The values of placeholders are sanitized to stay in one path element.
+type PathTemplate struct {
+	raw string
+	// parts are literals at even indexes and placeholders at odd indexes
+	parts []string
+}
+
+// ParsePathTemplate parses the template like "{user.id}/{illust.id}{ext}".
+func ParsePathTemplate(s string) (*PathTemplate, error) {
+	t := &PathTemplate{raw: s}
+	rest := s
+	for {
+		i := strings.IndexAny(rest, "{}")
+		if i == -1 {
+			t.parts = append(t.parts, rest)
+			return t, nil
+		}
+		if rest[i] == '}' {
+			return nil, fmt.Errorf("unexpected '}' in path template %q", s)
+		}
+		j := strings.IndexAny(rest[i+1:], "{}")
+		if j == -1 || rest[i+1+j] == '{' {
+			return nil, fmt.Errorf("unclosed '{' in path template %q", s)
+		}
+		name := rest[i+1 : i+1+j]
+		if name == "" {
+			return nil, fmt.Errorf("empty placeholder in path template %q", s)
+		}
+		t.parts = append(t.parts, rest[:i], name)
+		rest = rest[i+j+2:]
+	}
+}
+
+func (t *PathTemplate) String() string {
+	return t.raw
+}
+
+// Execute returns the slash-separated path with the placeholders
+// replaced by the sanitized values in fields.
+func (t *PathTemplate) Execute(fields map[string]string) (string, error) {
+	b := strings.Builder{}
+	for i, p := range t.parts {
+		if i%2 == 0 {
+			b.WriteString(p)
+			continue
+		}
+		v, ok := fields[p]
+		if !ok {
+			return "", fmt.Errorf("unknown placeholder {%s} in path template %q", p, t.raw)
+		}
+		b.WriteString(helper.SanitizeName(v))
+	}
+	p := path.Clean(b.String())
+	if p == "." || p == ".." || strings.HasPrefix(p, "../") || path.IsAbs(p) {
+		return "", fmt.Errorf("path %q from template %q is out of the directory", p, t.raw)
+	}
+	return p, nil
+}
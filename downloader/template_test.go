@@ -0,0 +1,49 @@
+package downloader
+
+import "testing"
+
+func TestPathTemplate(t *testing.T) {
+	fields := map[string]string{
+		"user.name":    "a/b",
+		"illust.id":    "123",
+		"illust.title": " .. ",
+		"page":         "0",
+		"ext":          ".jpg",
+	}
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{"{user.name}/{illust.id}/{page}{ext}", "a／b/123/0.jpg"},
+		{"{illust.title} ({illust.id})/{page}{ext}", "．． (123)/0.jpg"},
+		{"{illust.title}/{page}{ext}", "．．/0.jpg"},
+		{"static/{illust.id}{ext}", "static/123.jpg"},
+	}
+	for _, tt := range tests {
+		pt, err := ParsePathTemplate(tt.template)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := pt.Execute(fields)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.template, got, tt.want)
+		}
+	}
+
+	for _, s := range []string{"{user.id", "user.id}", "{}", "{a{b}}"} {
+		if _, err := ParsePathTemplate(s); err == nil {
+			t.Errorf("%q: expected error", s)
+		}
+	}
+	pt, _ := ParsePathTemplate("{unknown}{ext}")
+	if _, err := pt.Execute(fields); err == nil {
+		t.Error("expected error of unknown placeholder")
+	}
+	pt, _ = ParsePathTemplate("../{ext}")
+	if _, err := pt.Execute(fields); err == nil {
+		t.Error("expected error of path out of the directory")
+	}
+}
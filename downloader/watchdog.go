@@ -0,0 +1,79 @@
+package downloader
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// watchdog cancels a connection of a task which stalls
+// or runs past the deadline of the task.
+type watchdog struct {
+	cancel context.CancelFunc
+	idle   time.Duration
+	timers []*time.Timer
+
+	mu  sync.Mutex
+	err error
+}
+
+// newWatchdog returns a context canceled when no bytes are received
+// in idle, or the deadline of the task is reached.
+// Zero idle and deadline disable the checks.
+func newWatchdog(ctx context.Context, idle time.Duration, deadline time.Time) (context.Context, *watchdog) {
+	ctx, cancel := context.WithCancel(ctx)
+	w := &watchdog{cancel: cancel, idle: idle}
+	if idle > 0 {
+		w.timers = append(w.timers, time.AfterFunc(idle, func() { w.fire(ErrIdleTimeout) }))
+	}
+	if !deadline.IsZero() {
+		w.timers = append(w.timers, time.AfterFunc(time.Until(deadline), func() { w.fire(ErrTaskTimeout) }))
+	}
+	return ctx, w
+}
+
+func (w *watchdog) fire(err error) {
+	w.mu.Lock()
+	if w.err == nil {
+		w.err = err
+	}
+	w.mu.Unlock()
+	w.cancel()
+}
+
+// reset restarts the idle timer.
+func (w *watchdog) reset() {
+	if w.idle > 0 {
+		w.timers[0].Reset(w.idle)
+	}
+}
+
+// stop stops the timers and releases the context.
+func (w *watchdog) stop() {
+	for _, t := range w.timers {
+		t.Stop()
+	}
+	w.cancel()
+}
+
+// Err returns the reason why the connection is canceled by the watchdog.
+func (w *watchdog) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// watchdogReader resets the idle timer of the watchdog on every read.
+type watchdogReader struct {
+	r io.Reader
+	w *watchdog
+}
+
+func (r *watchdogReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.w.reset()
+	}
+	return n, err
+}
@@ -0,0 +1,143 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/WOo0W/bowerbird/cli/log"
+)
+
+func TestStall(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	stalled := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		if !stalled {
+			stalled = true
+			w.Header().Set("Content-Length", "10000")
+			w.Write(content[:5000])
+			w.(http.Flusher).Flush()
+			// stop sending bytes without closing the connection
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+			return
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer ts.Close()
+
+	ctx := log.NewContext(context.Background(), log.New())
+	d := NewWithCliet(ctx, ts.Client())
+	d.RetryWaitMin = time.Millisecond
+	d.RetryWaitMax = 10 * time.Millisecond
+	d.IdleTimeout = 100 * time.Millisecond
+	d.Start()
+	defer d.Stop()
+
+	req, err := http.NewRequest("GET", ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	task := &Task{Request: req, LocalPath: filepath.Join(t.TempDir(), "f"), NoImageChecking: true}
+	start := time.Now()
+	d.Add(task)
+	d.Wait()
+
+	if task.Status != Finished {
+		t.Fatalf("unexpected status %s: %v", task.Status, task.Err)
+	}
+	if e := time.Since(start); e > 3*time.Second {
+		t.Errorf("stall is not detected in time: %s", e)
+	}
+	b, _ := ioutil.ReadFile(task.LocalPath)
+	if !bytes.Equal(b, content) {
+		t.Error("content doesn't match")
+	}
+}
+
+// slowServer sends content in 100 bytes every 10ms from the start of Range.
+func slowServer(content []byte) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := 0
+		if rg := r.Header.Get("Range"); rg != "" {
+			fmt.Sscanf(rg, "bytes=%d-", &start)
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(content)-1, len(content)))
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)-start))
+			w.WriteHeader(http.StatusPartialContent)
+		} else {
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		}
+		for i := start; i < len(content); i += 100 {
+			end := i + 100
+			if end > len(content) {
+				end = len(content)
+			}
+			w.Write(content[i:end])
+			w.(http.Flusher).Flush()
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}))
+}
+
+func TestTaskTimeout(t *testing.T) {
+	// about 1s to send the whole content
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	ts := slowServer(content)
+	defer ts.Close()
+
+	ctx := log.NewContext(context.Background(), log.New())
+	d := NewWithCliet(ctx, ts.Client())
+	d.RetryWaitMin = time.Millisecond
+	d.RetryWaitMax = time.Millisecond
+	d.TaskTimeout = 300 * time.Millisecond
+	d.Start()
+	defer d.Stop()
+
+	newTask := func() *Task {
+		req, err := http.NewRequest("GET", ts.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &Task{Request: req, LocalPath: filepath.Join(t.TempDir(), "f"), NoImageChecking: true}
+	}
+
+	// reconnects with the downloaded part after the task timeout
+	d.TriesMax = 10
+	task := newTask()
+	d.Add(task)
+	d.Wait()
+	if task.Status != Finished {
+		t.Fatalf("unexpected status %s: %v", task.Status, task.Err)
+	}
+	b, _ := ioutil.ReadFile(task.LocalPath)
+	if !bytes.Equal(b, content) {
+		t.Error("content doesn't match")
+	}
+
+	// fails after the max tries
+	d.TriesMax = 2
+	task = newTask()
+	start := time.Now()
+	d.Add(task)
+	d.Wait()
+	if task.Status != Failed || task.Err != ErrTaskTimeout {
+		t.Fatalf("unexpected status %s: %v", task.Status, task.Err)
+	}
+	if e := time.Since(start); e > 2*time.Second {
+		t.Errorf("the task timeout is not applied: %s", e)
+	}
+}
@@ -0,0 +1,249 @@
+package export
+
+import (
+	"archive/zip"
+	"fmt"
+	"html"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/WOo0W/bowerbird/helper/novel"
+)
+
+const epubContainer = `<?xml version="1.0" encoding="UTF-8"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+`
+
+const epubStyle = `body { line-height: 1.8; }
+h1, h2, h3 { line-height: 1.4; }
+img { max-width: 100%; }
+.info { margin: 1em 0; }
+.caption { margin: 1em 0; font-size: 0.9em; }
+`
+
+// epubItem is an item in the manifest of EPUB.
+type epubItem struct {
+	id, href, mediaType, properties string
+	content                         []byte
+	// spine is true for the XHTML documents in the reading order.
+	spine bool
+}
+
+// WriteEPUB writes b to w as EPUB 3.
+// Each novel starts with a page of its cover, author, tags and caption,
+// and each [newpage] of it is an XHTML document. The chapters are in the
+// table of contents.
+func WriteEPUB(w io.Writer, b *Book) error {
+	lang := b.Language
+	if lang == "" {
+		lang = "ja"
+	}
+	modified := b.Modified
+	if modified.IsZero() {
+		modified = time.Now()
+	}
+
+	items := []*epubItem{{id: "style", href: "style.css", mediaType: "text/css", content: []byte(epubStyle)}}
+	imageHref := make(map[*Image]string)
+	for i, im := range b.Images() {
+		it := &epubItem{
+			id:        "image" + strconv.Itoa(i+1),
+			href:      "images/" + im.Name,
+			mediaType: im.mediaType(),
+			content:   im.Data,
+		}
+		if im == b.Cover {
+			it.properties = "cover-image"
+		}
+		imageHref[im] = it.href
+		items = append(items, it)
+	}
+
+	var nav strings.Builder
+	tags := []string{}
+	seenTags := make(map[string]struct{})
+	for i, n := range b.Novels {
+		for _, t := range n.Tags {
+			if _, ok := seenTags[t]; !ok {
+				seenTags[t] = struct{}{}
+				tags = append(tags, t)
+			}
+		}
+
+		prefix := "n" + strconv.Itoa(i+1)
+		pageHref := func(page int) string {
+			return prefix + "-p" + strconv.Itoa(page) + ".xhtml"
+		}
+		infoHref := prefix + "-info.xhtml"
+		items = append(items, &epubItem{
+			id: prefix + "-info", href: infoHref, mediaType: "application/xhtml+xml",
+			content: xhtmlDocument(lang, n.Title, novelInfoHTML(n, imageHref)),
+			spine:   true,
+		})
+
+		opts := &novel.HTMLOptions{
+			ImageSrc: func(im *novel.Image) string {
+				return imageHref[n.Images[*im]]
+			},
+			JumpHref: pageHref,
+		}
+		for j, p := range n.Doc.Pages {
+			items = append(items, &epubItem{
+				id: prefix + "-p" + strconv.Itoa(j+1), href: pageHref(j + 1), mediaType: "application/xhtml+xml",
+				content: xhtmlDocument(lang, n.Title, novel.HTML(p.Nodes, opts)),
+				spine:   true,
+			})
+		}
+
+		var chapters strings.Builder
+		for _, c := range n.Doc.Chapters() {
+			fmt.Fprintf(&chapters, "<li><a href=\"%s\">%s</a></li>\n", pageHref(c.Page), html.EscapeString(c.Title))
+		}
+		if len(b.Novels) == 1 {
+			fmt.Fprintf(&nav, "<li><a href=\"%s\">%s</a></li>\n%s", infoHref, html.EscapeString(n.Title), chapters.String())
+		} else if chapters.Len() == 0 {
+			fmt.Fprintf(&nav, "<li><a href=\"%s\">%s</a></li>\n", infoHref, html.EscapeString(n.Title))
+		} else {
+			fmt.Fprintf(&nav, "<li><a href=\"%s\">%s</a>\n<ol>\n%s</ol>\n</li>\n", infoHref, html.EscapeString(n.Title), chapters.String())
+		}
+	}
+	items = append(items, &epubItem{
+		id: "nav", href: "nav.xhtml", mediaType: "application/xhtml+xml", properties: "nav",
+		content: xhtmlDocument(lang, b.Title,
+			"<nav epub:type=\"toc\" id=\"toc\">\n<h1>"+html.EscapeString(b.Title)+"</h1>\n<ol>\n"+nav.String()+"</ol>\n</nav>"),
+	})
+
+	zw := zip.NewWriter(w)
+	// mimetype must be the first file without compression
+	f, err := zw.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(f, "application/epub+zip"); err != nil {
+		return err
+	}
+	files := []struct {
+		name    string
+		content []byte
+	}{
+		{"META-INF/container.xml", []byte(epubContainer)},
+		{"OEBPS/content.opf", epubPackage(b, lang, modified, tags, items)},
+	}
+	for _, it := range items {
+		files = append(files, struct {
+			name    string
+			content []byte
+		}{"OEBPS/" + it.href, it.content})
+	}
+	for _, file := range files {
+		f, err := zw.Create(file.name)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(file.content); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// epubPackage returns the package document content.opf.
+func epubPackage(b *Book, lang string, modified time.Time, tags []string, items []*epubItem) []byte {
+	esc := html.EscapeString
+	var sb strings.Builder
+	sb.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="bookid">
+<metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+`)
+	fmt.Fprintf(&sb, "<dc:identifier id=\"bookid\">%s</dc:identifier>\n", esc(b.ID))
+	fmt.Fprintf(&sb, "<dc:title>%s</dc:title>\n", esc(b.Title))
+	fmt.Fprintf(&sb, "<dc:language>%s</dc:language>\n", esc(lang))
+	if b.Author != "" {
+		fmt.Fprintf(&sb, "<dc:creator>%s</dc:creator>\n", esc(b.Author))
+	}
+	for _, t := range tags {
+		fmt.Fprintf(&sb, "<dc:subject>%s</dc:subject>\n", esc(t))
+	}
+	if len(b.Novels) == 1 {
+		n := b.Novels[0]
+		if n.Caption != "" {
+			fmt.Fprintf(&sb, "<dc:description>%s</dc:description>\n", esc(n.Caption))
+		}
+		if !n.Date.IsZero() {
+			fmt.Fprintf(&sb, "<dc:date>%s</dc:date>\n", n.Date.UTC().Format(time.RFC3339))
+		}
+	}
+	fmt.Fprintf(&sb, "<meta property=\"dcterms:modified\">%s</meta>\n", modified.UTC().Format("2006-01-02T15:04:05Z"))
+	for _, it := range items {
+		if it.properties == "cover-image" {
+			// for EPUB 2 readers
+			fmt.Fprintf(&sb, "<meta name=\"cover\" content=\"%s\"/>\n", it.id)
+		}
+	}
+	sb.WriteString("</metadata>\n<manifest>\n")
+	for _, it := range items {
+		fmt.Fprintf(&sb, "<item id=\"%s\" href=\"%s\" media-type=\"%s\"", it.id, esc(it.href), it.mediaType)
+		if it.properties != "" {
+			fmt.Fprintf(&sb, " properties=\"%s\"", it.properties)
+		}
+		sb.WriteString("/>\n")
+	}
+	sb.WriteString("</manifest>\n<spine>\n")
+	for _, it := range items {
+		if it.spine {
+			fmt.Fprintf(&sb, "<itemref idref=\"%s\"/>\n", it.id)
+		}
+	}
+	sb.WriteString("</spine>\n</package>\n")
+	return []byte(sb.String())
+}
+
+// novelInfoHTML returns the body of the first page of the novel.
+func novelInfoHTML(n *Novel, imageHref map[*Image]string) string {
+	esc := html.EscapeString
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "<h1>%s</h1>\n", esc(n.Title))
+	if href, ok := imageHref[n.Cover]; ok && n.Cover != nil {
+		fmt.Fprintf(&sb, "<p><img src=\"%s\" alt=\"cover\" /></p>\n", esc(href))
+	}
+	sb.WriteString("<div class=\"info\">\n")
+	if n.Author != "" {
+		fmt.Fprintf(&sb, "<p>%s</p>\n", esc(n.Author))
+	}
+	if d := formatDate(n.Date); d != "" {
+		fmt.Fprintf(&sb, "<p>%s</p>\n", d)
+	}
+	if len(n.Tags) != 0 {
+		fmt.Fprintf(&sb, "<p>%s</p>\n", esc(strings.Join(n.Tags, " / ")))
+	}
+	if n.URL != "" {
+		fmt.Fprintf(&sb, "<p><a href=\"%s\">%s</a></p>\n", esc(n.URL), esc(n.URL))
+	}
+	sb.WriteString("</div>\n")
+	if n.Caption != "" {
+		fmt.Fprintf(&sb, "<div class=\"caption\">%s</div>\n", textHTML(n.Caption))
+	}
+	return sb.String()
+}
+
+func xhtmlDocument(lang, title, body string) []byte {
+	return []byte(fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" xml:lang="%[1]s" lang="%[1]s">
+<head>
+<meta charset="UTF-8" />
+<title>%[2]s</title>
+<link rel="stylesheet" type="text/css" href="style.css" />
+</head>
+<body>
+%[3]s
+</body>
+</html>
+`, html.EscapeString(lang), html.EscapeString(title), body))
+}
@@ -0,0 +1,218 @@
+// Package export writes archived novels as EPUB 3 or Markdown books.
+package export
+
+import (
+	"html"
+	"io"
+	"mime"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/WOo0W/bowerbird/helper"
+	"github.com/WOo0W/bowerbird/helper/novel"
+)
+
+// Book is one or more novels exported as a file.
+type Book struct {
+	// ID is the unique identifier of the book like "urn:pixiv:novel:12345".
+	ID       string
+	Title    string
+	Author   string
+	Language string
+	Cover    *Image
+	Novels   []*Novel
+	// Modified is the modification time in EPUB. time.Now is used if it is zero.
+	Modified time.Time
+}
+
+// Novel is a novel in Book.
+type Novel struct {
+	Title   string
+	Author  string
+	URL     string
+	Date    time.Time
+	Tags    []string
+	Caption string
+	Cover   *Image
+	Doc     *novel.Document
+	// Images are the images embedded in Doc.
+	// The images not in it are skipped.
+	Images map[novel.Image]*Image
+}
+
+// Image is an image file in Book.
+type Image struct {
+	// Name is the file name like "12345_p0.jpg", which is unique in the Book.
+	Name string
+	Data []byte
+}
+
+func (im *Image) mediaType() string {
+	if t := mime.TypeByExtension(path.Ext(im.Name)); t != "" {
+		return strings.SplitN(t, ";", 2)[0]
+	}
+	return "image/jpeg"
+}
+
+// Images returns the covers and the embedded images in b.
+func (b *Book) Images() []*Image {
+	seen := make(map[string]struct{})
+	ims := []*Image{}
+	add := func(im *Image) {
+		if im == nil {
+			return
+		}
+		if _, ok := seen[im.Name]; !ok {
+			seen[im.Name] = struct{}{}
+			ims = append(ims, im)
+		}
+	}
+	add(b.Cover)
+	for _, n := range b.Novels {
+		add(n.Cover)
+		for _, im := range n.Doc.Images() {
+			add(n.Images[*im])
+		}
+	}
+	return ims
+}
+
+var blankLines = regexp.MustCompile(`\n{3,}`)
+
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`,
+	"[", `\[`, "]", `\]`, "<", `\<`, ">", `\>`, "#", `\#`,
+)
+
+// markdownURLEscaper escapes the characters ending the URL of Markdown links.
+var markdownURLEscaper = strings.NewReplacer(" ", "%20", "(", "%28", ")", "%29", "<", "%3C", ">", "%3E")
+
+// WriteMarkdown writes b to w as Markdown,
+// linking to the images under imageDir written by the caller.
+// Ruby is written as HTML.
+func WriteMarkdown(w io.Writer, b *Book, imageDir string) error {
+	var sb strings.Builder
+	imageLink := func(im *Image) string {
+		return "![](" + path.Join(imageDir, im.Name) + ")\n\n"
+	}
+
+	h := "#"
+	if len(b.Novels) > 1 {
+		sb.WriteString("# " + markdownEscaper.Replace(b.Title) + "\n\n")
+		if b.Author != "" {
+			sb.WriteString(markdownEscaper.Replace(b.Author) + "\n\n")
+		}
+		if b.Cover != nil {
+			sb.WriteString(imageLink(b.Cover))
+		}
+		h = "##"
+	}
+
+	for i, n := range b.Novels {
+		sb.WriteString(h + " " + markdownEscaper.Replace(n.Title) + "\n\n")
+		if n.Cover != nil && (len(b.Novels) == 1 || n.Cover != b.Cover) {
+			sb.WriteString(imageLink(n.Cover))
+		}
+		for _, m := range [][2]string{
+			{"Author", n.Author},
+			{"Date", formatDate(n.Date)},
+			{"Tags", strings.Join(n.Tags, ", ")},
+			{"Source", n.URL},
+		} {
+			if m[1] != "" {
+				sb.WriteString("- " + m[0] + ": " + markdownEscaper.Replace(m[1]) + "\n")
+			}
+		}
+		sb.WriteString("\n")
+		if n.Caption != "" {
+			for _, l := range strings.Split(n.Caption, "\n") {
+				sb.WriteString(strings.TrimRight("> "+markdownEscaper.Replace(l), " ") + "\n")
+			}
+			sb.WriteString("\n")
+		}
+
+		anchor := func(page int) string {
+			return "n" + strconv.Itoa(i+1) + "-page-" + strconv.Itoa(page)
+		}
+		for j, p := range n.Doc.Pages {
+			if j > 0 {
+				sb.WriteString("\n\n---\n\n")
+			}
+			sb.WriteString(`<a id="` + anchor(j+1) + `"></a>` + "\n\n")
+			writeMarkdownNodes(&sb, p.Nodes, h+"#", anchor, func(im *novel.Image) string {
+				if eim := n.Images[*im]; eim != nil {
+					return imageLink(eim)
+				}
+				return ""
+			})
+		}
+		sb.WriteString("\n\n")
+	}
+	s := blankLines.ReplaceAllString(strings.TrimSpace(sb.String()), "\n\n")
+	_, err := io.WriteString(w, s+"\n")
+	return err
+}
+
+func writeMarkdownNodes(sb *strings.Builder, nodes []novel.Node, h string, anchor func(page int) string, image func(im *novel.Image) string) {
+	for _, nd := range nodes {
+		switch nd := nd.(type) {
+		case *novel.Text:
+			lines := strings.Split(strings.ReplaceAll(nd.Text, "\r\n", "\n"), "\n")
+			for i, l := range lines {
+				if i > 0 {
+					// hard line breaks, keeping the blank lines as they are
+					if lines[i-1] != "" && l != "" {
+						sb.WriteString("  ")
+					}
+					sb.WriteString("\n")
+				}
+				sb.WriteString(markdownEscaper.Replace(l))
+			}
+		case *novel.Ruby:
+			sb.WriteString(novel.HTML([]novel.Node{nd}, nil))
+		case *novel.JumpURI:
+			if !nd.IsWeb() {
+				sb.WriteString(markdownEscaper.Replace(nd.Text))
+				continue
+			}
+			sb.WriteString("[" + markdownEscaper.Replace(nd.Text) + "](" + markdownURLEscaper.Replace(nd.URL) + ")")
+		case *novel.Jump:
+			sb.WriteString("[" + strconv.Itoa(nd.Page) + "](#" + anchor(nd.Page) + ")")
+		case *novel.Image:
+			sb.WriteString("\n\n" + image(nd))
+		case *novel.Chapter:
+			sb.WriteString("\n\n" + h + " ")
+			writeMarkdownNodes(sb, nd.Title, h, anchor, image)
+			sb.WriteString("\n\n")
+		}
+	}
+}
+
+func formatDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format("2006-01-02")
+}
+
+// textHTML escapes the text and converts the line breaks to <br />.
+func textHTML(s string) string {
+	return strings.ReplaceAll(html.EscapeString(s), "\n", "<br />\n")
+}
+
+// FileName returns the name of the file of b with the extension ext,
+// prefixed with the end of the ID like "novel-12345_title.epub"
+// so the books with the same title don't overwrite each other.
+func (b *Book) FileName(ext string) string {
+	name := helper.SanitizeName(b.Title)
+	if name == "" {
+		name = "untitled"
+	}
+	if parts := strings.Split(b.ID, ":"); len(parts) >= 2 {
+		name = helper.SanitizeName(parts[len(parts)-2]+"-"+parts[len(parts)-1]) + "_" + name
+	}
+	return name + ext
+}
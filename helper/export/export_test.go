@@ -0,0 +1,140 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/WOo0W/bowerbird/helper/novel"
+)
+
+func testBook() *Book {
+	cover := &Image{Name: "cover.jpg", Data: []byte("jpeg")}
+	return &Book{
+		ID:     "urn:pixiv:novel:1",
+		Title:  "Title <1>",
+		Author: "Author",
+		Cover:  cover,
+		Novels: []*Novel{{
+			Title:   "Title <1>",
+			Author:  "Author",
+			URL:     "https://www.pixiv.net/novel/show.php?id=1",
+			Date:    time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+			Tags:    []string{"オリジナル", "R-18"},
+			Caption: "caption & \nline",
+			Cover:   cover,
+			Doc: novel.Parse("[chapter:One]\n[[rb:漢字 > かんじ]] *text*\n[uploadedimage:1]\n" +
+				"[newpage]\n[chapter:Two]\n[jump:1] " +
+				"[[jumpuri:Link > https://example.com/a b(c)]] [[jumpuri:Script > javascript:alert(1)]]"),
+			Images: map[novel.Image]*Image{
+				{Type: "uploadedimage", ID: "1"}: {Name: "1.png", Data: []byte("png")},
+			},
+		}},
+		Modified: time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+func TestWriteEPUB(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := WriteEPUB(buf, testBook()); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f := zr.File[0]; f.Name != "mimetype" || f.Method != zip.Store {
+		t.Errorf("the first file is %q with method %d", f.Name, f.Method)
+	}
+
+	files := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name] = string(b)
+		if strings.HasSuffix(f.Name, ".xhtml") || strings.HasSuffix(f.Name, ".opf") {
+			d := xml.NewDecoder(bytes.NewReader(b))
+			d.Strict = true
+			for {
+				_, err := d.Token()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Errorf("%s: %v", f.Name, err)
+					break
+				}
+			}
+		}
+	}
+
+	for name, want := range map[string][]string{
+		"OEBPS/content.opf": {
+			`<dc:title>Title &lt;1&gt;</dc:title>`,
+			`<dc:subject>R-18</dc:subject>`,
+			`href="images/cover.jpg" media-type="image/jpeg" properties="cover-image"`,
+			`<itemref idref="n1-info"/>`, `<itemref idref="n1-p2"/>`,
+		},
+		"OEBPS/nav.xhtml":        {`<a href="n1-p1.xhtml">One</a>`, `<a href="n1-p2.xhtml">Two</a>`},
+		"OEBPS/n1-info.xhtml":    {`<img src="images/cover.jpg"`, "caption &amp; <br />\nline"},
+		"OEBPS/n1-p1.xhtml":      {`<img src="images/1.png"`, `<rt>かんじ</rt>`},
+		"OEBPS/n1-p2.xhtml":      {`<a href="n1-p1.xhtml">1</a>`},
+		"OEBPS/images/1.png":     {"png"},
+		"META-INF/container.xml": {"OEBPS/content.opf"},
+	} {
+		for _, s := range want {
+			if !strings.Contains(files[name], s) {
+				t.Errorf("%s does not contain %q:\n%s", name, s, files[name])
+			}
+		}
+	}
+}
+
+func TestWriteMarkdown(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := WriteMarkdown(buf, testBook(), "images"); err != nil {
+		t.Fatal(err)
+	}
+	want := "# Title \\<1\\>\n\n" +
+		"![](images/cover.jpg)\n\n" +
+		"- Author: Author\n" +
+		"- Date: 2020-01-02\n" +
+		"- Tags: オリジナル, R-18\n" +
+		"- Source: https://www.pixiv.net/novel/show.php?id=1\n\n" +
+		"> caption &\n" +
+		"> line\n\n" +
+		"<a id=\"n1-page-1\"></a>\n\n" +
+		"## One\n\n" +
+		"<ruby>漢字<rp>(</rp><rt>かんじ</rt><rp>)</rp></ruby> \\*text\\*\n\n" +
+		"![](images/1.png)\n\n" +
+		"---\n\n" +
+		"<a id=\"n1-page-2\"></a>\n\n" +
+		"## Two\n\n" +
+		"[1](#n1-page-1) [Link](https://example.com/a%20b%28c%29) Script\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestFileName(t *testing.T) {
+	b := testBook()
+	if got := b.FileName(".epub"); !strings.HasPrefix(got, "novel-1_") || !strings.HasSuffix(got, ".epub") {
+		t.Errorf("unexpected file name %q", got)
+	}
+	b.ID = "urn:pixiv:novel-series:1"
+	if got := b.FileName(".md"); !strings.HasPrefix(got, "novel-series-1_") {
+		t.Errorf("unexpected file name %q", got)
+	}
+}
@@ -0,0 +1,246 @@
+// Package filter parses and evaluates the filter expressions of works like
+//
+//	bookmarks > 1000 && type == "manga" && !tag("R-18") && date >= 2020-01-01
+//
+// The fields are type, user, pages, views, bookmarks, restrict and date.
+// tag("a", "b") reports whether the work has any of the tags,
+// and `user in [1, 2]` whether the field is in the list.
+package filter
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Work is a work the expressions are evaluated on.
+type Work struct {
+	Type      string
+	UserID    int
+	Pages     int
+	Views     int
+	Bookmarks int
+	// Restrict is "all-ages", "R-18" or "R-18G".
+	Restrict string
+	Date     time.Time
+	// Tags are the original and translated names of the tags.
+	Tags []string
+}
+
+type kind int
+
+const (
+	kindBool kind = iota + 1
+	kindNumber
+	kindString
+	kindDate
+	kindList
+)
+
+func (k kind) String() string {
+	switch k {
+	case kindBool:
+		return "bool"
+	case kindNumber:
+		return "number"
+	case kindString:
+		return "string"
+	case kindDate:
+		return "date"
+	case kindList:
+		return "list"
+	}
+	return "unknown"
+}
+
+type value struct {
+	b bool
+	n int64
+	s string
+	t time.Time
+	l []value
+}
+
+// fields are the fields of Work in expressions.
+var fields = map[string]struct {
+	kind kind
+	get  func(w *Work) value
+}{
+	"type":      {kindString, func(w *Work) value { return value{s: w.Type} }},
+	"user":      {kindNumber, func(w *Work) value { return value{n: int64(w.UserID)} }},
+	"pages":     {kindNumber, func(w *Work) value { return value{n: int64(w.Pages)} }},
+	"views":     {kindNumber, func(w *Work) value { return value{n: int64(w.Views)} }},
+	"bookmarks": {kindNumber, func(w *Work) value { return value{n: int64(w.Bookmarks)} }},
+	"restrict":  {kindString, func(w *Work) value { return value{s: w.Restrict} }},
+	// the date in the time zone of the work, compared with date literals
+	"date": {kindDate, func(w *Work) value { return value{t: dateOf(w.Date)} }},
+}
+
+func dateOf(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// node is a node of the syntax tree.
+// The kinds are checked after parsing, so eval never fails.
+type node interface {
+	kind() kind
+	eval(w *Work) value
+}
+
+type literal struct {
+	k kind
+	v value
+}
+
+func (n *literal) kind() kind         { return n.k }
+func (n *literal) eval(w *Work) value { return n.v }
+
+type field struct {
+	k   kind
+	get func(w *Work) value
+}
+
+func (n *field) kind() kind         { return n.k }
+func (n *field) eval(w *Work) value { return n.get(w) }
+
+type list struct {
+	elem  kind
+	elems []node
+}
+
+func (n *list) kind() kind { return kindList }
+func (n *list) eval(w *Work) value {
+	l := make([]value, 0, len(n.elems))
+	for _, e := range n.elems {
+		l = append(l, e.eval(w))
+	}
+	return value{l: l}
+}
+
+type not struct{ x node }
+
+func (n *not) kind() kind         { return kindBool }
+func (n *not) eval(w *Work) value { return value{b: !n.x.eval(w).b} }
+
+type logic struct {
+	op   string
+	l, r node
+}
+
+func (n *logic) kind() kind { return kindBool }
+func (n *logic) eval(w *Work) value {
+	l := n.l.eval(w).b
+	if n.op == "&&" {
+		return value{b: l && n.r.eval(w).b}
+	}
+	return value{b: l || n.r.eval(w).b}
+}
+
+type compare struct {
+	op   string
+	k    kind // kind of operands
+	l, r node
+}
+
+func (n *compare) kind() kind { return kindBool }
+func (n *compare) eval(w *Work) value {
+	l, r := n.l.eval(w), n.r.eval(w)
+	if n.op == "in" {
+		for _, e := range r.l {
+			if cmp(n.k, l, e) == 0 {
+				return value{b: true}
+			}
+		}
+		return value{b: false}
+	}
+	c := cmp(n.k, l, r)
+	switch n.op {
+	case "==":
+		return value{b: c == 0}
+	case "!=":
+		return value{b: c != 0}
+	case "<":
+		return value{b: c < 0}
+	case "<=":
+		return value{b: c <= 0}
+	case ">":
+		return value{b: c > 0}
+	}
+	return value{b: c >= 0}
+}
+
+func cmp(k kind, a, b value) int {
+	switch k {
+	case kindBool:
+		if a.b == b.b {
+			return 0
+		}
+		return 1
+	case kindNumber:
+		switch {
+		case a.n < b.n:
+			return -1
+		case a.n > b.n:
+			return 1
+		}
+		return 0
+	case kindString:
+		return strings.Compare(a.s, b.s)
+	case kindDate:
+		switch {
+		case a.t.Before(b.t):
+			return -1
+		case a.t.After(b.t):
+			return 1
+		}
+	}
+	return 0
+}
+
+// tagCall is tag("a", "b").
+type tagCall struct{ names []string }
+
+func (n *tagCall) kind() kind { return kindBool }
+func (n *tagCall) eval(w *Work) value {
+	for _, t := range w.Tags {
+		for _, name := range n.names {
+			if strings.EqualFold(t, name) {
+				return value{b: true}
+			}
+		}
+	}
+	return value{b: false}
+}
+
+// Expr is a parsed filter expression.
+type Expr struct {
+	src  string
+	root node
+}
+
+// Parse parses the expression s.
+func Parse(s string) (*Expr, error) {
+	p := &parser{lex: &lexer{src: s}}
+	p.next()
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.typ != tokEOF {
+		return nil, p.errorf("unexpected %q", p.tok.text)
+	}
+	if root.kind() != kindBool {
+		return nil, fmt.Errorf("filter: expression is %s, not bool", root.kind())
+	}
+	return &Expr{src: s, root: root}, nil
+}
+
+// Match reports whether the work matches e.
+func (e *Expr) Match(w *Work) bool {
+	return e.root.eval(w).b
+}
+
+func (e *Expr) String() string {
+	return e.src
+}
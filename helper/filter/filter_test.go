@@ -0,0 +1,65 @@
+package filter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMatch(t *testing.T) {
+	w := &Work{
+		Type:      "manga",
+		UserID:    11,
+		Pages:     3,
+		Views:     20000,
+		Bookmarks: 1500,
+		Restrict:  "all-ages",
+		Date:      time.Date(2020, 3, 1, 23, 0, 0, 0, time.FixedZone("JST", 9*60*60)),
+		Tags:      []string{"オリジナル", "original"},
+	}
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{`bookmarks > 1000 && type == "manga" && !tag("R-18") && date >= 2020-01-01`, true},
+		{`bookmarks > 1000 && pages == 1`, false},
+		{`tag("Original")`, true},
+		{`tag("R-18", "R-18G") || views < 100`, false},
+		{`!(user in [1, 11])`, false},
+		{`user in []`, false},
+		{`restrict != "R-18" && date == 2020-03-01`, true},
+		{`date < 2020-03-01 || date > 2020-03-01`, false},
+		{`type in ["illust", "ugoira"] || tag("original") == true`, true},
+	}
+	for _, tt := range tests {
+		e, err := Parse(tt.expr)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.expr, err)
+			continue
+		}
+		if got := e.Match(w); got != tt.want {
+			t.Errorf("%q: got %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestParseError(t *testing.T) {
+	for _, s := range []string{
+		``,
+		`bookmarks`,
+		`bookmarks > "1000"`,
+		`likes > 1`,
+		`tag()`,
+		`tag(1)`,
+		`!views`,
+		`(pages > 1`,
+		`user in [1, "2"]`,
+		`date >= 2020-1-1`,
+		`type == "manga`,
+		`true < false`,
+		`pages > 1 pages`,
+	} {
+		if _, err := Parse(s); err == nil {
+			t.Errorf("Parse(%q): expected error", s)
+		}
+	}
+}
@@ -0,0 +1,325 @@
+package filter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+type tokenType int
+
+const (
+	tokEOF tokenType = iota
+	tokIdent
+	tokNumber
+	tokString
+	tokDate
+	tokOp
+)
+
+type token struct {
+	typ  tokenType
+	text string
+	pos  int
+}
+
+type lexer struct {
+	src string
+	pos int
+}
+
+// dateLen is the length of YYYY-MM-DD.
+const dateLen = len("2006-01-02")
+
+func (l *lexer) next() (token, error) {
+	for l.pos < len(l.src) && unicode.IsSpace(rune(l.src[l.pos])) {
+		l.pos++
+	}
+	start := l.pos
+	if l.pos >= len(l.src) {
+		return token{typ: tokEOF, pos: start}, nil
+	}
+	c := l.src[l.pos]
+	switch {
+	case isDigit(c):
+		for l.pos < len(l.src) && (isDigit(l.src[l.pos]) || l.src[l.pos] == '-') {
+			l.pos++
+		}
+		text := l.src[start:l.pos]
+		if strings.Contains(text, "-") {
+			if len(text) != dateLen {
+				return token{}, fmt.Errorf("filter: bad date %q at %d", text, start)
+			}
+			return token{typ: tokDate, text: text, pos: start}, nil
+		}
+		return token{typ: tokNumber, text: text, pos: start}, nil
+	case c == '_' || unicode.IsLetter(rune(c)):
+		for l.pos < len(l.src) && (l.src[l.pos] == '_' || isDigit(l.src[l.pos]) || unicode.IsLetter(rune(l.src[l.pos]))) {
+			l.pos++
+		}
+		return token{typ: tokIdent, text: l.src[start:l.pos], pos: start}, nil
+	case c == '"' || c == '`':
+		l.pos++
+		for l.pos < len(l.src) && l.src[l.pos] != c {
+			if c == '"' && l.src[l.pos] == '\\' {
+				l.pos++
+			}
+			l.pos++
+		}
+		if l.pos >= len(l.src) {
+			return token{}, fmt.Errorf("filter: unterminated string at %d", start)
+		}
+		l.pos++
+		return token{typ: tokString, text: l.src[start:l.pos], pos: start}, nil
+	}
+	for _, op := range []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "(", ")", "[", "]", ","} {
+		if strings.HasPrefix(l.src[l.pos:], op) {
+			l.pos += len(op)
+			return token{typ: tokOp, text: op, pos: start}, nil
+		}
+	}
+	return token{}, fmt.Errorf("filter: unexpected %q at %d", c, start)
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+// parser is a recursive descent parser of:
+//
+//	or      = and { "||" and }
+//	and     = cmp { "&&" cmp }
+//	cmp     = unary [ ( "==" | "!=" | "<" | "<=" | ">" | ">=" | "in" ) unary ]
+//	unary   = "!" unary | primary
+//	primary = literal | field | call | list | "(" or ")"
+type parser struct {
+	lex *lexer
+	tok token
+	err error
+}
+
+func (p *parser) next() {
+	if p.err != nil {
+		return
+	}
+	p.tok, p.err = p.lex.next()
+}
+
+func (p *parser) errorf(format string, a ...interface{}) error {
+	if p.err != nil {
+		return p.err
+	}
+	return fmt.Errorf("filter: %s at %d", fmt.Sprintf(format, a...), p.tok.pos)
+}
+
+func (p *parser) isOp(op string) bool {
+	return p.err == nil && p.tok.typ == tokOp && p.tok.text == op
+}
+
+func (p *parser) expect(op string) error {
+	if !p.isOp(op) {
+		return p.errorf("expected %q", op)
+	}
+	p.next()
+	return p.err
+}
+
+func (p *parser) parseOr() (node, error) {
+	return p.parseLogic("||", p.parseAnd)
+}
+
+func (p *parser) parseAnd() (node, error) {
+	return p.parseLogic("&&", p.parseCompare)
+}
+
+func (p *parser) parseLogic(op string, operand func() (node, error)) (node, error) {
+	l, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp(op) {
+		pos := p.tok.pos
+		p.next()
+		r, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		if l.kind() != kindBool || r.kind() != kindBool {
+			return nil, fmt.Errorf("filter: %s of %s and %s at %d", op, l.kind(), r.kind(), pos)
+		}
+		l = &logic{op: op, l: l, r: r}
+	}
+	return l, p.err
+}
+
+func (p *parser) parseCompare() (node, error) {
+	l, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	var op string
+	switch {
+	case p.tok.typ == tokOp && strings.ContainsAny(p.tok.text, "=<>"):
+		op = p.tok.text
+	case p.tok.typ == tokIdent && p.tok.text == "in":
+		op = "in"
+	default:
+		return l, p.err
+	}
+	pos := p.tok.pos
+	p.next()
+	r, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	k := l.kind()
+	switch {
+	case op == "in":
+		li, ok := r.(*list)
+		if !ok || (len(li.elems) != 0 && li.elem != k) {
+			return nil, fmt.Errorf("filter: %s in %s at %d", k, r.kind(), pos)
+		}
+	case k != r.kind() || k == kindList:
+		return nil, fmt.Errorf("filter: %s %s %s at %d", k, op, r.kind(), pos)
+	case k == kindBool && op != "==" && op != "!=":
+		return nil, fmt.Errorf("filter: bool %s bool at %d", op, pos)
+	}
+	return &compare{op: op, k: k, l: l, r: r}, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.isOp("!") {
+		pos := p.tok.pos
+		p.next()
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if x.kind() != kindBool {
+			return nil, fmt.Errorf("filter: !%s at %d", x.kind(), pos)
+		}
+		return &not{x: x}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	t := p.tok
+	switch t.typ {
+	case tokNumber:
+		n, err := strconv.ParseInt(t.text, 10, 64)
+		if err != nil {
+			return nil, p.errorf("bad number %q", t.text)
+		}
+		p.next()
+		return &literal{k: kindNumber, v: value{n: n}}, p.err
+	case tokString:
+		s, err := strconv.Unquote(t.text)
+		if err != nil {
+			return nil, p.errorf("bad string %s", t.text)
+		}
+		p.next()
+		return &literal{k: kindString, v: value{s: s}}, p.err
+	case tokDate:
+		d, err := time.Parse("2006-01-02", t.text)
+		if err != nil {
+			return nil, p.errorf("bad date %q", t.text)
+		}
+		p.next()
+		return &literal{k: kindDate, v: value{t: d}}, p.err
+	case tokIdent:
+		p.next()
+		switch t.text {
+		case "true", "false":
+			return &literal{k: kindBool, v: value{b: t.text == "true"}}, p.err
+		case "tag":
+			return p.parseTag()
+		}
+		f, ok := fields[t.text]
+		if !ok {
+			return nil, fmt.Errorf("filter: unknown field %q at %d", t.text, t.pos)
+		}
+		return &field{k: f.kind, get: f.get}, p.err
+	case tokOp:
+		switch t.text {
+		case "(":
+			p.next()
+			x, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			return x, p.expect(")")
+		case "[":
+			return p.parseList()
+		}
+	case tokEOF:
+		return nil, p.errorf("unexpected end")
+	}
+	return nil, p.errorf("unexpected %q", t.text)
+}
+
+// parseList parses a list of literals like [1, 2, 3].
+func (p *parser) parseList() (node, error) {
+	p.next()
+	li := &list{}
+	for !p.isOp("]") {
+		if len(li.elems) != 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		pos := p.tok.pos
+		e, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := e.(*literal); !ok {
+			return nil, fmt.Errorf("filter: list element is not a literal at %d", pos)
+		}
+		if len(li.elems) != 0 && e.kind() != li.elem {
+			return nil, fmt.Errorf("filter: %s in list of %s at %d", e.kind(), li.elem, pos)
+		}
+		li.elem = e.kind()
+		li.elems = append(li.elems, e)
+	}
+	p.next()
+	return li, p.err
+}
+
+// parseTag parses the arguments of tag("a", "b").
+func (p *parser) parseTag() (node, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	tc := &tagCall{}
+	for !p.isOp(")") {
+		if len(tc.names) != 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		if p.err != nil {
+			return nil, p.err
+		}
+		if p.tok.typ != tokString {
+			return nil, p.errorf("tag() takes strings")
+		}
+		s, err := strconv.Unquote(p.tok.text)
+		if err != nil {
+			return nil, p.errorf("bad string %s", p.tok.text)
+		}
+		tc.names = append(tc.names, s)
+		p.next()
+	}
+	if len(tc.names) == 0 {
+		return nil, p.errorf("tag() takes at least one tag")
+	}
+	p.next()
+	return tc, p.err
+}
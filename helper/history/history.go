@@ -0,0 +1,179 @@
+// Package history lists the versions of the PostDetail and UserDetail
+// of a post or user, with the changed fields between them.
+//
+// A new detail document is saved each time any field of it changes,
+// except the fields derived from the others, which are backfilled
+// on the latest document instead. So the documents of a post or user
+// in the order of creation are the versions of it.
+package history
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/WOo0W/bowerbird/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type d = bson.D
+
+// Version is a version of the detail of a post or user.
+type Version struct {
+	ID primitive.ObjectID `json:"id"`
+	// SavedAt is when the version was found for the first time.
+	SavedAt time.Time `json:"savedAt"`
+	// Detail is the *model.PostDetail or *model.UserDetail.
+	Detail interface{} `json:"detail"`
+	// Changes are the fields changed from the previous version.
+	Changes []Change `json:"changes"`
+}
+
+// Change is a field changed between two versions.
+type Change struct {
+	// Field is the path of the field like "extension.pixivIllust.title".
+	Field string `json:"field"`
+	// Old and New are nil if the field is added or removed.
+	Old interface{} `json:"old"`
+	New interface{} `json:"new"`
+}
+
+// the fields linking the details, which are not compared
+var ignoredFields = map[string]struct{}{
+	"_id":    {},
+	"postID": {},
+	"userID": {},
+}
+
+// Diff returns the changes of the fields from a to b in the order of the fields.
+// The embedded documents are compared by fields, and the arrays as a whole.
+func Diff(a, b d) []Change {
+	fa, fb := make(map[string]interface{}), make(map[string]interface{})
+	keys := []string{}
+	flatten(a, "", fa, &keys)
+	flatten(b, "", fb, &keys)
+
+	seen := make(map[string]struct{}, len(keys))
+	cs := []Change{}
+	for _, k := range keys {
+		if _, ok := seen[k]; ok {
+			continue
+		}
+		seen[k] = struct{}{}
+		if _, ok := ignoredFields[k]; ok {
+			continue
+		}
+		va, vb := fa[k], fb[k]
+		if !reflect.DeepEqual(va, vb) {
+			cs = append(cs, Change{Field: k, Old: va, New: vb})
+		}
+	}
+	return cs
+}
+
+func flatten(doc d, prefix string, m map[string]interface{}, keys *[]string) {
+	for _, e := range doc {
+		k := prefix + e.Key
+		if sub, ok := e.Value.(d); ok && len(sub) != 0 {
+			flatten(sub, k+".", m, keys)
+			continue
+		}
+		m[k] = plain(e.Value)
+		*keys = append(*keys, k)
+	}
+}
+
+// plain converts the documents and arrays in v to maps and slices.
+func plain(v interface{}) interface{} {
+	switch v := v.(type) {
+	case d:
+		m := make(map[string]interface{}, len(v))
+		for _, e := range v {
+			m[e.Key] = plain(e.Value)
+		}
+		return m
+	case primitive.A:
+		s := make([]interface{}, 0, len(v))
+		for _, x := range v {
+			s = append(s, plain(x))
+		}
+		return s
+	}
+	return v
+}
+
+// versions finds the documents in c with the key in the order of creation,
+// decoding each with newDetail.
+func versions(ctx context.Context, c *mongo.Collection, key string, id primitive.ObjectID, newDetail func() interface{}) ([]*Version, error) {
+	cur, err := c.Find(ctx, d{{Key: key, Value: id}},
+		options.Find().SetSort(d{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	vs := []*Version{}
+	var prev d
+	for cur.Next(ctx) {
+		doc := d{}
+		if err := cur.Decode(&doc); err != nil {
+			return nil, err
+		}
+		v := &Version{Detail: newDetail(), Changes: []Change{}}
+		if err := cur.Decode(v.Detail); err != nil {
+			return nil, err
+		}
+		if oid, ok := cur.Current.Lookup("_id").ObjectIDOK(); ok {
+			v.ID = oid
+			v.SavedAt = oid.Timestamp()
+		}
+		if prev != nil {
+			v.Changes = Diff(prev, doc)
+		}
+		prev = doc
+		vs = append(vs, v)
+	}
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+	return vs, nil
+}
+
+// PostVersions lists the PostDetail versions of the post with the ID.
+func PostVersions(ctx context.Context, db *mongo.Database, postID primitive.ObjectID) ([]*Version, error) {
+	return versions(ctx, db.Collection(model.CollectionPostDetail), "postID", postID,
+		func() interface{} { return &model.PostDetail{} })
+}
+
+// UserVersions lists the UserDetail versions of the user with the ID.
+func UserVersions(ctx context.Context, db *mongo.Database, userID primitive.ObjectID) ([]*Version, error) {
+	return versions(ctx, db.Collection(model.CollectionUserDetail), "userID", userID,
+		func() interface{} { return &model.UserDetail{} })
+}
+
+func findID(ctx context.Context, c *mongo.Collection, source interface{}, sid, name string) (primitive.ObjectID, error) {
+	r, err := c.FindOne(ctx,
+		d{{Key: "source", Value: source}, {Key: "sourceID", Value: sid}},
+		options.FindOne().SetProjection(d{{Key: "_id", Value: 1}})).DecodeBytes()
+	if err == mongo.ErrNoDocuments {
+		return primitive.NilObjectID, fmt.Errorf("%s %s is not found in database", name, sid)
+	}
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	return r.Lookup("_id").ObjectID(), nil
+}
+
+// PostID finds the ID of the post with the source and the ID on the source.
+func PostID(ctx context.Context, db *mongo.Database, source model.PostSource, sid string) (primitive.ObjectID, error) {
+	return findID(ctx, db.Collection(model.CollectionPost), source, sid, string(source))
+}
+
+// UserID finds the ID of the user with the source and the ID on the source.
+func UserID(ctx context.Context, db *mongo.Database, source model.Source, sid string) (primitive.ObjectID, error) {
+	return findID(ctx, db.Collection(model.CollectionUser), source, sid, string(source)+" user")
+}
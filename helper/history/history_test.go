@@ -0,0 +1,43 @@
+package history
+
+import (
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestDiff(t *testing.T) {
+	a := d{
+		{Key: "_id", Value: primitive.NewObjectID()},
+		{Key: "name", Value: "old"},
+		{Key: "extension", Value: d{{Key: "pixiv", Value: d{
+			{Key: "bio", Value: "bio"},
+			{Key: "workspace", Value: d{{Key: "pc", Value: "a"}}},
+			{Key: "tags", Value: primitive.A{"x", "y"}},
+		}}}},
+	}
+	b := d{
+		{Key: "_id", Value: primitive.NewObjectID()},
+		{Key: "name", Value: "new"},
+		{Key: "extension", Value: d{{Key: "pixiv", Value: d{
+			{Key: "bio", Value: "bio"},
+			{Key: "workspace", Value: d{{Key: "monitor", Value: "b"}}},
+			{Key: "tags", Value: primitive.A{"x", d{{Key: "z", Value: 1}}}},
+		}}}},
+	}
+	want := []Change{
+		{Field: "name", Old: "old", New: "new"},
+		{Field: "extension.pixiv.workspace.pc", Old: "a"},
+		{Field: "extension.pixiv.tags",
+			Old: []interface{}{"x", "y"},
+			New: []interface{}{"x", map[string]interface{}{"z": 1}}},
+		{Field: "extension.pixiv.workspace.monitor", New: "b"},
+	}
+	if got := Diff(a, b); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+	if got := Diff(a, a); len(got) != 0 {
+		t.Errorf("got %#v for the same documents", got)
+	}
+}
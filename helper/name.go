@@ -0,0 +1,37 @@
+package helper
+
+import (
+	"runtime"
+	"strings"
+)
+
+// Replace special characters in path with fullwidth characters
+
+var replacerAll = strings.NewReplacer(
+	"/", "／",
+)
+
+var replacerOnWindows = strings.NewReplacer(
+	":", "：",
+	"*", "＊",
+	"?", "？",
+	"\"", "“",
+	"<", "＜",
+	">", "＞",
+	"|", "｜",
+	"\\", "／",
+)
+
+// SanitizeName replaces the special characters in the file name
+// with fullwidth characters.
+func SanitizeName(s string) string {
+	s = replacerAll.Replace(s)
+	if runtime.GOOS == "windows" {
+		s = replacerOnWindows.Replace(s)
+	}
+	s = strings.TrimSpace(s)
+	if s == "." || s == ".." {
+		s = strings.Repeat("．", len(s))
+	}
+	return s
+}
@@ -0,0 +1,302 @@
+// Package novel parses the markup of pixiv novels into a syntax tree,
+// and renders it to HTML and plain text.
+//
+// The markup is:
+//
+//	[newpage]
+//	[chapter:Title]
+//	[[rb:漢字 > かんじ]]
+//	[[jumpuri:Text > https://example.com]]
+//	[jump:2]
+//	[uploadedimage:12345]
+//	[pixivimage:67890-2]
+package novel
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Node is a node in a page.
+type Node interface {
+	node()
+}
+
+// Text is plain text with line breaks.
+type Text struct {
+	Text string
+}
+
+// Ruby is [[rb:Base > Reading]].
+type Ruby struct {
+	Base    string
+	Reading string
+}
+
+// JumpURI is [[jumpuri:Text > URL]].
+type JumpURI struct {
+	Text string
+	URL  string
+}
+
+// IsWeb reports whether the URL is in http or https.
+// The others like "javascript:" are rendered as plain text.
+func (j *JumpURI) IsWeb() bool {
+	u, err := url.Parse(j.URL)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// Jump is [jump:Page], a link to the page starting from 1.
+type Jump struct {
+	Page int
+}
+
+// Image is [uploadedimage:ID] or [pixivimage:ID-page].
+type Image struct {
+	// Type is "uploadedimage" or "pixivimage".
+	Type string
+	// ID is the ID of the uploaded image like "12345",
+	// or the illust ID and page like "67890-2".
+	// The page of pixivimage is 1 if it is omitted in the markup.
+	ID string
+}
+
+// Chapter is [chapter:Title]. The title may contain Ruby.
+type Chapter struct {
+	Title []Node
+}
+
+func (*Text) node()    {}
+func (*Ruby) node()    {}
+func (*JumpURI) node() {}
+func (*Jump) node()    {}
+func (*Image) node()   {}
+func (*Chapter) node() {}
+
+// Page is a page of the novel separated by [newpage].
+type Page struct {
+	Nodes []Node
+}
+
+// Document is a parsed novel.
+type Document struct {
+	Pages []*Page
+}
+
+// ChapterInfo is a chapter in the Document.
+type ChapterInfo struct {
+	Title string
+	// Page is the page of the chapter, starting from 1.
+	Page int
+}
+
+// Chapters returns the chapters in the Document in order.
+func (doc *Document) Chapters() []ChapterInfo {
+	cs := []ChapterInfo{}
+	for i, p := range doc.Pages {
+		for _, n := range p.Nodes {
+			if c, ok := n.(*Chapter); ok {
+				cs = append(cs, ChapterInfo{Title: PlainText(c.Title), Page: i + 1})
+			}
+		}
+	}
+	return cs
+}
+
+// Images returns the images in the Document in order,
+// with the images appearing more than once returned once.
+func (doc *Document) Images() []*Image {
+	seen := make(map[Image]struct{})
+	ims := []*Image{}
+	for _, p := range doc.Pages {
+		for _, n := range p.Nodes {
+			if im, ok := n.(*Image); ok {
+				if _, ok := seen[*im]; !ok {
+					seen[*im] = struct{}{}
+					ims = append(ims, im)
+				}
+			}
+		}
+	}
+	return ims
+}
+
+// Parse parses the text of a novel.
+// The markup not recognized is kept as Text.
+func Parse(text string) *Document {
+	doc := &Document{}
+	p := &Page{}
+	var buf strings.Builder
+	flush := func() {
+		if buf.Len() != 0 {
+			p.Nodes = append(p.Nodes, &Text{Text: buf.String()})
+			buf.Reset()
+		}
+	}
+	// trimBlock removes a line break around the markup of blocks
+	trimBlock := func() {
+		s := strings.TrimSuffix(buf.String(), "\n")
+		buf.Reset()
+		buf.WriteString(s)
+		flush()
+	}
+
+	for i := 0; i < len(text); {
+		if text[i] != '[' {
+			j := strings.IndexByte(text[i:], '[')
+			if j < 0 {
+				j = len(text) - i
+			}
+			buf.WriteString(text[i : i+j])
+			i += j
+			continue
+		}
+
+		rest := text[i:]
+		switch {
+		case strings.HasPrefix(rest, "[newpage]"):
+			trimBlock()
+			doc.Pages = append(doc.Pages, p)
+			p = &Page{}
+			i += len("[newpage]")
+			i += skipLineBreak(text[i:])
+			continue
+		case strings.HasPrefix(rest, "[chapter:"):
+			title, n := parseChapterTitle(rest[len("[chapter:"):])
+			if n >= 0 {
+				trimBlock()
+				p.Nodes = append(p.Nodes, &Chapter{Title: title})
+				i += len("[chapter:") + n
+				i += skipLineBreak(text[i:])
+				continue
+			}
+		default:
+			if nd, n := parseInline(rest); nd != nil {
+				flush()
+				p.Nodes = append(p.Nodes, nd)
+				i += n
+				continue
+			}
+		}
+		buf.WriteByte('[')
+		i++
+	}
+	flush()
+	doc.Pages = append(doc.Pages, p)
+	return doc
+}
+
+func skipLineBreak(s string) int {
+	if strings.HasPrefix(s, "\r\n") {
+		return 2
+	}
+	if strings.HasPrefix(s, "\n") {
+		return 1
+	}
+	return 0
+}
+
+// parseChapterTitle parses the title after "[chapter:",
+// and returns the length including the closing "]", or -1 if it is not closed.
+func parseChapterTitle(s string) ([]Node, int) {
+	nodes := []Node{}
+	var buf strings.Builder
+	for i := 0; i < len(s); {
+		switch {
+		case s[i] == ']':
+			if buf.Len() != 0 {
+				nodes = append(nodes, &Text{Text: buf.String()})
+			}
+			return nodes, i + 1
+		case s[i] == '\n':
+			return nil, -1
+		case strings.HasPrefix(s[i:], "[[rb:"):
+			if nd, n := parseInline(s[i:]); nd != nil {
+				if buf.Len() != 0 {
+					nodes = append(nodes, &Text{Text: buf.String()})
+					buf.Reset()
+				}
+				nodes = append(nodes, nd)
+				i += n
+				continue
+			}
+		}
+		buf.WriteByte(s[i])
+		i++
+	}
+	return nil, -1
+}
+
+// parseInline parses the inline markup at the start of s,
+// and returns the node and its length, or nil if it is not valid.
+func parseInline(s string) (Node, int) {
+	switch {
+	case strings.HasPrefix(s, "[[rb:"):
+		body, n := enclosed(s, "[[rb:", "]]")
+		if base, reading, ok := splitArrow(body); ok && n > 0 {
+			return &Ruby{Base: base, Reading: reading}, n
+		}
+	case strings.HasPrefix(s, "[[jumpuri:"):
+		body, n := enclosed(s, "[[jumpuri:", "]]")
+		if text, u, ok := splitArrow(body); ok && n > 0 {
+			return &JumpURI{Text: text, URL: u}, n
+		}
+	case strings.HasPrefix(s, "[jump:"):
+		body, n := enclosed(s, "[jump:", "]")
+		if page, err := strconv.Atoi(strings.TrimSpace(body)); err == nil && n > 0 && page > 0 {
+			return &Jump{Page: page}, n
+		}
+	case strings.HasPrefix(s, "[uploadedimage:"):
+		body, n := enclosed(s, "[uploadedimage:", "]")
+		if n > 0 && isDigits(body) {
+			return &Image{Type: "uploadedimage", ID: body}, n
+		}
+	case strings.HasPrefix(s, "[pixivimage:"):
+		body, n := enclosed(s, "[pixivimage:", "]")
+		id, page := body, "1"
+		if j := strings.IndexByte(body, '-'); j >= 0 {
+			id, page = body[:j], body[j+1:]
+		}
+		if n > 0 && isDigits(id) && isDigits(page) {
+			return &Image{Type: "pixivimage", ID: id + "-" + page}, n
+		}
+	}
+	return nil, 0
+}
+
+// enclosed returns the text between open at the start of s and the first close
+// in the line, and the length of all of them, or -1 if close is not found.
+func enclosed(s, open, close string) (string, int) {
+	s = s[len(open):]
+	if j := strings.IndexByte(s, '\n'); j >= 0 {
+		s = s[:j]
+	}
+	j := strings.Index(s, close)
+	if j < 0 {
+		return "", -1
+	}
+	return s[:j], len(open) + j + len(close)
+}
+
+// splitArrow splits "a > b" into "a" and "b".
+func splitArrow(s string) (string, string, bool) {
+	j := strings.Index(s, ">")
+	if j < 0 {
+		return "", "", false
+	}
+	a, b := strings.TrimSpace(s[:j]), strings.TrimSpace(s[j+1:])
+	return a, b, a != "" && b != ""
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
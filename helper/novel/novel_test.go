@@ -0,0 +1,63 @@
+package novel
+
+import (
+	"reflect"
+	"testing"
+)
+
+const testText = "[chapter:第一章 [[rb:序 > じょ]]]\n" +
+	"[[rb:漢字 > かんじ]]の<本文>\n" +
+	"[uploadedimage:123]\n" +
+	"[newpage]\n" +
+	"[[jumpuri:Link > https://example.com/?a=1&b=2]] [jump:1] [[jumpuri:Script > javascript:alert(1)]]\n" +
+	"[pixivimage:456][pixivimage:456-1][unknown] [[rb:broken]]\n" +
+	"[chapter:第二章]\n" +
+	"end"
+
+func TestParse(t *testing.T) {
+	doc := Parse(testText)
+	if len(doc.Pages) != 2 {
+		t.Fatalf("got %d pages, want 2", len(doc.Pages))
+	}
+	want := []ChapterInfo{{"第一章 序(じょ)", 1}, {"第二章", 2}}
+	if got := doc.Chapters(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Chapters() = %v, want %v", got, want)
+	}
+	wantIms := []*Image{{"uploadedimage", "123"}, {"pixivimage", "456-1"}}
+	if got := doc.Images(); !reflect.DeepEqual(got, wantIms) {
+		t.Errorf("Images() = %v, want %v", got, wantIms)
+	}
+}
+
+func TestRender(t *testing.T) {
+	doc := Parse(testText)
+	wantText := "第一章 序(じょ)\n" +
+		"漢字(かんじ)の<本文>\n" +
+		"\n\n" +
+		"Link (https://example.com/?a=1&b=2) 1 Script\n" +
+		"[unknown] [[rb:broken]]\n" +
+		"第二章\n" +
+		"end"
+	if got := doc.PlainText(); got != wantText {
+		t.Errorf("PlainText() = %q, want %q", got, wantText)
+	}
+
+	wantHTML := `<section id="page-1">` + "\n" +
+		"<h2>第一章 <ruby>序<rp>(</rp><rt>じょ</rt><rp>)</rp></ruby></h2>\n" +
+		"<ruby>漢字<rp>(</rp><rt>かんじ</rt><rp>)</rp></ruby>の&lt;本文&gt;<br />\n" +
+		`<img src="img/123" alt="uploadedimage:123" />` +
+		"\n</section>\n" +
+		`<section id="page-2">` + "\n" +
+		`<a href="https://example.com/?a=1&amp;b=2">Link</a> <a href="#page-1">1</a> Script<br />` + "\n" +
+		"[unknown] [[rb:broken]]<h2>第二章</h2>\n" +
+		"end\n</section>\n"
+	got := doc.HTML(&HTMLOptions{ImageSrc: func(im *Image) string {
+		if im.Type == "uploadedimage" {
+			return "img/" + im.ID
+		}
+		return ""
+	}})
+	if got != wantHTML {
+		t.Errorf("HTML() = %q, want %q", got, wantHTML)
+	}
+}
@@ -0,0 +1,122 @@
+package novel
+
+import (
+	"html"
+	"strconv"
+	"strings"
+)
+
+// HTMLOptions customizes the rendering of HTML.
+type HTMLOptions struct {
+	// ImageSrc returns the src of the image.
+	// The images are skipped if it is nil or returns "".
+	ImageSrc func(im *Image) string
+	// JumpHref returns the href of the link to the page starting from 1.
+	// It is "#page-N" if JumpHref is nil.
+	JumpHref func(page int) string
+}
+
+func (o *HTMLOptions) imageSrc(im *Image) string {
+	if o == nil || o.ImageSrc == nil {
+		return ""
+	}
+	return o.ImageSrc(im)
+}
+
+func (o *HTMLOptions) jumpHref(page int) string {
+	if o == nil || o.JumpHref == nil {
+		return "#page-" + strconv.Itoa(page)
+	}
+	return o.JumpHref(page)
+}
+
+// HTML renders the Document to HTML, with each page in
+// <section id="page-N">. The output is valid XHTML as well.
+func (doc *Document) HTML(opts *HTMLOptions) string {
+	var b strings.Builder
+	for i, p := range doc.Pages {
+		b.WriteString(`<section id="page-` + strconv.Itoa(i+1) + `">` + "\n")
+		writeHTML(&b, p.Nodes, opts)
+		b.WriteString("\n</section>\n")
+	}
+	return b.String()
+}
+
+// HTML renders the nodes to HTML.
+func HTML(nodes []Node, opts *HTMLOptions) string {
+	var b strings.Builder
+	writeHTML(&b, nodes, opts)
+	return b.String()
+}
+
+func writeHTML(b *strings.Builder, nodes []Node, opts *HTMLOptions) {
+	for _, n := range nodes {
+		switch n := n.(type) {
+		case *Text:
+			lines := strings.Split(strings.ReplaceAll(n.Text, "\r\n", "\n"), "\n")
+			for i, l := range lines {
+				if i > 0 {
+					b.WriteString("<br />\n")
+				}
+				b.WriteString(html.EscapeString(l))
+			}
+		case *Ruby:
+			b.WriteString("<ruby>" + html.EscapeString(n.Base) +
+				"<rp>(</rp><rt>" + html.EscapeString(n.Reading) + "</rt><rp>)</rp></ruby>")
+		case *JumpURI:
+			if !n.IsWeb() {
+				b.WriteString(html.EscapeString(n.Text))
+				continue
+			}
+			b.WriteString(`<a href="` + html.EscapeString(n.URL) + `">` + html.EscapeString(n.Text) + "</a>")
+		case *Jump:
+			p := strconv.Itoa(n.Page)
+			b.WriteString(`<a href="` + html.EscapeString(opts.jumpHref(n.Page)) + `">` + p + "</a>")
+		case *Image:
+			if src := opts.imageSrc(n); src != "" {
+				b.WriteString(`<img src="` + html.EscapeString(src) + `" alt="` + n.Type + ":" + n.ID + `" />`)
+			}
+		case *Chapter:
+			b.WriteString("<h2>")
+			writeHTML(b, n.Title, opts)
+			b.WriteString("</h2>\n")
+		}
+	}
+}
+
+// PlainText renders the Document to plain text,
+// with the pages separated by blank lines.
+func (doc *Document) PlainText() string {
+	pages := make([]string, 0, len(doc.Pages))
+	for _, p := range doc.Pages {
+		pages = append(pages, PlainText(p.Nodes))
+	}
+	return strings.Join(pages, "\n\n")
+}
+
+// PlainText renders the nodes to plain text.
+// The readings of Ruby are put in parentheses, and the images are skipped.
+func PlainText(nodes []Node) string {
+	var b strings.Builder
+	for _, n := range nodes {
+		switch n := n.(type) {
+		case *Text:
+			b.WriteString(n.Text)
+		case *Ruby:
+			b.WriteString(n.Base + "(" + n.Reading + ")")
+		case *JumpURI:
+			b.WriteString(n.Text)
+			if n.IsWeb() {
+				b.WriteString(" (" + n.URL + ")")
+			}
+		case *Jump:
+			b.WriteString(strconv.Itoa(n.Page))
+		case *Chapter:
+			if b.Len() != 0 {
+				b.WriteString("\n")
+			}
+			b.WriteString(PlainText(n.Title) + "\n")
+		}
+	}
+	return b.String()
+}
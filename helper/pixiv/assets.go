@@ -0,0 +1,203 @@
+package pixiv
+
+import (
+	"context"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/WOo0W/bowerbird/cli/log"
+	"github.com/WOo0W/bowerbird/downloader"
+	"github.com/WOo0W/bowerbird/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// assetDirs are the directories of the images of user profiles and novels.
+var assetDirs = map[model.MediaType]string{
+	model.MediaPixivAvatar:            "avatars",
+	model.MediaPixivProfileBackground: "profile_background",
+	model.MediaPixivWorkspaceImage:    "workspace_images",
+	model.MediaPixivNovelCover:        "novel_covers",
+	model.MediaPixivNovelImage:        "novel_images",
+	model.MediaPixivNovelIllust:       "novel_images",
+}
+
+var (
+	userAssetTypes = []model.MediaType{
+		model.MediaPixivAvatar,
+		model.MediaPixivProfileBackground,
+		model.MediaPixivWorkspaceImage,
+	}
+	novelAssetTypes = []model.MediaType{
+		model.MediaPixivNovelCover,
+		model.MediaPixivNovelImage,
+		model.MediaPixivNovelIllust,
+	}
+)
+
+// assetPath returns the path of the image of user profile or novel with URL u,
+// like `avatars/12345_0123abcd_170_20200202123456.jpg`.
+func assetPath(t model.MediaType, u string) (string, error) {
+	uu, err := url.Parse(u)
+	if err != nil {
+		return "", err
+	}
+	fn := path.Base(uu.Path)
+	ext := path.Ext(fn)
+	date := strings.ReplaceAll(PximgDate.FindString(uu.Path), "/", "")
+	if date != "" {
+		date = "_" + date
+	}
+	return path.Join(assetDirs[t], strings.TrimSuffix(fn, ext)+date+ext), nil
+}
+
+// assetOwners are the pixiv users and novels saved in a run,
+// whose images are downloaded by queueAssets.
+type assetOwners struct {
+	users  map[int]struct{}
+	novels map[int]struct{}
+}
+
+func newAssetOwners() *assetOwners {
+	return &assetOwners{
+		users:  make(map[int]struct{}),
+		novels: make(map[int]struct{}),
+	}
+}
+
+func sourceIDs(m map[int]struct{}) []string {
+	ids := make([]string, 0, len(m))
+	for id := range m {
+		ids = append(ids, strconv.Itoa(id))
+	}
+	return ids
+}
+
+// mediaIDs returns the IDs of the avatars and profile images of the users
+// and the covers and images of the novels.
+func (o *assetOwners) mediaIDs(ctx context.Context, db *mongo.Database) ([]primitive.ObjectID, error) {
+	ids := []primitive.ObjectID{}
+	if len(o.users) != 0 {
+		cur, err := db.Collection(model.CollectionUser).Find(ctx, d{
+			{Key: "source", Value: model.SourcePixiv},
+			{Key: "sourceID", Value: d{{Key: "$in", Value: sourceIDs(o.users)}}},
+		})
+		if err != nil {
+			return nil, err
+		}
+		us := []model.User{}
+		if err := cur.All(ctx, &us); err != nil {
+			return nil, err
+		}
+		uids := make([]primitive.ObjectID, 0, len(us))
+		for _, u := range us {
+			uids = append(uids, u.ID)
+			ids = append(ids, u.AvatarIDs...)
+		}
+
+		cur, err = db.Collection(model.CollectionUserDetail).Find(ctx,
+			d{{Key: "userID", Value: d{{Key: "$in", Value: uids}}}})
+		if err != nil {
+			return nil, err
+		}
+		uds := []model.UserDetail{}
+		if err := cur.All(ctx, &uds); err != nil {
+			return nil, err
+		}
+		for _, ud := range uds {
+			if ud.Extension == nil || ud.Extension.Pixiv == nil {
+				continue
+			}
+			for _, id := range []primitive.ObjectID{ud.Extension.Pixiv.WorkspaceMediaID, ud.Extension.Pixiv.BackgroundMediaID} {
+				if !id.IsZero() {
+					ids = append(ids, id)
+				}
+			}
+		}
+	}
+
+	if len(o.novels) != 0 {
+		pids, err := postIDs(ctx, db.Collection(model.CollectionPost), model.PostSourcePixivNovel, sourceIDs(o.novels))
+		if err != nil {
+			return nil, err
+		}
+		cur, err := db.Collection(model.CollectionPostDetail).Find(ctx,
+			d{{Key: "postID", Value: d{{Key: "$in", Value: pids}}}},
+			options.Find().SetProjection(d{{Key: "mediaIDs", Value: 1}}))
+		if err != nil {
+			return nil, err
+		}
+		pds := []model.PostDetail{}
+		if err := cur.All(ctx, &pds); err != nil {
+			return nil, err
+		}
+		for _, pd := range pds {
+			ids = append(ids, pd.MediaIDs...)
+		}
+	}
+	return ids, nil
+}
+
+// queueAssets adds the tasks downloading the media of the types
+// of the users and novels in o which are not downloaded yet,
+// like the avatars in userAssetTypes.
+// The media removed from pixiv are skipped.
+func queueAssets(ctx context.Context, db *mongo.Database, dl *downloader.Downloader, basePath string, types []model.MediaType, o *assetOwners) (int, error) {
+	ids, err := o.mediaIDs(ctx, db)
+	if err != nil || len(ids) == 0 {
+		return 0, err
+	}
+	cm := db.Collection(model.CollectionMedia)
+	cur, err := cm.Find(ctx,
+		d{
+			{Key: "_id", Value: d{{Key: "$in", Value: ids}}},
+			{Key: "type", Value: d{{Key: "$in", Value: types}}},
+			{Key: "path", Value: d{{Key: "$exists", Value: false}}},
+			{Key: "gone", Value: d{{Key: "$ne", Value: true}}},
+		},
+		options.Find().SetProjection(d{{Key: "url", Value: 1}, {Key: "type", Value: 1}}))
+	if err != nil {
+		return 0, err
+	}
+	ms := []model.Media{}
+	if err := cur.All(ctx, &ms); err != nil {
+		return 0, err
+	}
+
+	// the images may be queued by the last call
+	queued := make(map[string]struct{})
+	for _, t := range dl.TaskList() {
+		queued[t.Request.URL.String()] = struct{}{}
+	}
+
+	logger := log.FromContext(ctx)
+	n := 0
+	for _, m := range ms {
+		if _, ok := queued[m.URL]; ok {
+			continue
+		}
+		fp, err := assetPath(m.Type, m.URL)
+		if err != nil {
+			logger.Error(err)
+			continue
+		}
+		req, err := newPximgRequest(m.URL)
+		if err != nil {
+			logger.Error(err)
+			continue
+		}
+		t := &downloader.Task{
+			Request:   req,
+			LocalPath: filepath.Join(basePath, filepath.FromSlash(fp)),
+		}
+		setAfterFinishedFunc(ctx, cm, t, m.URL, fp)
+		setAfterFailedFunc(ctx, cm, t, m.URL)
+		dl.Add(t)
+		n++
+	}
+	return n, nil
+}
@@ -0,0 +1,236 @@
+package pixiv
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/WOo0W/bowerbird/cli/log"
+	"github.com/WOo0W/bowerbird/model"
+	"github.com/WOo0W/bowerbird/storage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// BookmarkSync records the works found by a crawl of the bookmarks of a user.
+// If the crawl starts from the first page and reaches the end,
+// the bookmarks not found are recorded as removed.
+type BookmarkSync struct {
+	Source   model.PostSource
+	UserID   string
+	Restrict string
+	// FromStart is true if the crawl starts from the first page.
+	FromStart bool
+
+	found []string
+	added int64
+}
+
+func (bs *BookmarkSync) filter() d {
+	return d{
+		{Key: "source", Value: bs.Source},
+		{Key: "userID", Value: bs.UserID},
+		{Key: "restrict", Value: bs.Restrict},
+	}
+}
+
+// add records the works with the sourceIDs found in the bookmarks.
+func (bs *BookmarkSync) add(ctx context.Context, cb *mongo.Collection, sids []string) error {
+	if bs == nil || cb == nil {
+		return nil
+	}
+	for _, sid := range sids {
+		r, err := cb.UpdateOne(ctx,
+			append(bs.filter(), d{
+				{Key: "sourceID", Value: sid},
+				{Key: "removedAt", Value: d{{Key: "$exists", Value: false}}},
+			}...),
+			d{{Key: "$setOnInsert", Value: d{{Key: "addedAt", Value: time.Now()}}}},
+			optsUUpsert)
+		if err != nil {
+			return err
+		}
+		bs.added += r.UpsertedCount
+	}
+	bs.found = append(bs.found, sids...)
+	return nil
+}
+
+// finish records the bookmarks not found as removed if the crawl is complete.
+func (bs *BookmarkSync) finish(ctx context.Context, cb *mongo.Collection, complete bool) error {
+	if bs == nil || cb == nil {
+		return nil
+	}
+	logger := log.FromContext(ctx)
+	if bs.added > 0 {
+		logger.Info(bs.added, "new bookmarks found")
+	}
+	if !complete || !bs.FromStart {
+		return nil
+	}
+	if bs.found == nil {
+		bs.found = []string{}
+	}
+	r, err := cb.UpdateMany(ctx,
+		append(bs.filter(), d{
+			{Key: "removedAt", Value: d{{Key: "$exists", Value: false}}},
+			{Key: "sourceID", Value: d{{Key: "$nin", Value: bs.found}}},
+		}...),
+		d{{Key: "$currentDate", Value: d{{Key: "removedAt", Value: true}}}})
+	if err != nil {
+		return err
+	}
+	if r.ModifiedCount > 0 {
+		logger.Info(r.ModifiedCount, "works were removed from bookmarks since the last sync")
+	}
+	return nil
+}
+
+// RemovedWork is a work removed from bookmarks or invisible on pixiv.
+type RemovedWork struct {
+	Post  *model.Post
+	Title string
+	// RemovedAt is the latest time the work was found removed from bookmarks,
+	// or zero if it is in bookmarks.
+	RemovedAt time.Time
+	// Files are the paths of the files of the work still in storage.
+	Files []string
+	// Missing are the paths of the files of the work not in storage.
+	Missing []string
+}
+
+// removedBookmarks returns the latest time of removal of the works
+// removed from bookmarks and not added back.
+func removedBookmarks(ctx context.Context, cb *mongo.Collection) (map[model.PostSource]map[string]time.Time, error) {
+	cur, err := cb.Find(ctx, d{})
+	if err != nil {
+		return nil, err
+	}
+	bs := []model.Bookmark{}
+	if err := cur.All(ctx, &bs); err != nil {
+		return nil, err
+	}
+
+	removed := make(map[model.PostSource]map[string]time.Time)
+	active := make(map[model.PostSource]map[string]struct{})
+	for _, b := range bs {
+		if removed[b.Source] == nil {
+			removed[b.Source] = make(map[string]time.Time)
+			active[b.Source] = make(map[string]struct{})
+		}
+		if b.RemovedAt.IsZero() {
+			active[b.Source][b.SourceID] = struct{}{}
+		} else if b.RemovedAt.After(removed[b.Source][b.SourceID]) {
+			removed[b.Source][b.SourceID] = b.RemovedAt
+		}
+	}
+	for source, m := range removed {
+		for sid := range m {
+			if _, ok := active[source][sid]; ok {
+				delete(m, sid)
+			}
+		}
+	}
+	return removed, nil
+}
+
+// RemovedWorks finds the works removed from bookmarks or invisible on pixiv,
+// with their files checked in s, sorted by the time of removal.
+func RemovedWorks(ctx context.Context, db *mongo.Database, s storage.Storage) ([]*RemovedWork, error) {
+	removed, err := removedBookmarks(ctx, db.Collection(model.CollectionBookmark))
+	if err != nil {
+		return nil, err
+	}
+	or := a{d{{Key: "sourceInvisible", Value: true}}}
+	for source, m := range removed {
+		sids := make([]string, 0, len(m))
+		for sid := range m {
+			sids = append(sids, sid)
+		}
+		or = append(or, d{
+			{Key: "source", Value: source},
+			{Key: "sourceID", Value: d{{Key: "$in", Value: sids}}},
+		})
+	}
+	cur, err := db.Collection(model.CollectionPost).Aggregate(ctx,
+		pipelinePostsWithOwner(d{{Key: "$or", Value: or}}))
+	if err != nil {
+		return nil, err
+	}
+	ps := []*model.Post{}
+	if err := cur.All(ctx, &ps); err != nil {
+		return nil, err
+	}
+
+	cm := db.Collection(model.CollectionMedia)
+	ws := make([]*RemovedWork, 0, len(ps))
+	for _, p := range ps {
+		w := &RemovedWork{Post: p, RemovedAt: removed[p.Source][p.SourceID]}
+		if pd := p.PostDetail; pd != nil {
+			if pd.Extension != nil && pd.Extension.PixivIllust != nil {
+				w.Title = pd.Extension.PixivIllust.Title
+			} else if pd.Extension != nil && pd.Extension.PixivNovel != nil {
+				w.Title = pd.Extension.PixivNovel.Title
+			}
+			w.Files, w.Missing, err = mediaFiles(ctx, cm, s, postMediaIDs(pd))
+			if err != nil {
+				return nil, err
+			}
+		}
+		ws = append(ws, w)
+	}
+	sort.SliceStable(ws, func(i, j int) bool {
+		return ws[i].RemovedAt.Before(ws[j].RemovedAt)
+	})
+	return ws, nil
+}
+
+// mediaFiles returns the paths of the media with the IDs in s and not in s.
+// The animations converted from ugoira are included.
+func mediaFiles(ctx context.Context, cm *mongo.Collection, s storage.Storage, ids []primitive.ObjectID) ([]string, []string, error) {
+	cur, err := cm.Find(ctx, d{{Key: "_id", Value: d{{Key: "$in", Value: ids}}}})
+	if err != nil {
+		return nil, nil, err
+	}
+	ms := []model.Media{}
+	if err := cur.All(ctx, &ms); err != nil {
+		return nil, nil, err
+	}
+	var files, missing []string
+	for _, m := range ms {
+		ps := []string{m.Path}
+		if m.Extension != nil && m.Extension.Pixiv != nil && m.Extension.Pixiv.ConvertedPath != "" {
+			ps = append(ps, m.Extension.Pixiv.ConvertedPath)
+		}
+		for _, p := range ps {
+			if p == "" {
+				missing = append(missing, path.Base(m.URL))
+				continue
+			}
+			if _, err := s.Stat(ctx, p); err != nil {
+				missing = append(missing, p)
+			} else {
+				files = append(files, p)
+			}
+		}
+	}
+	return files, missing, nil
+}
+
+// RemovedWorkString returns the line of w in the list of removed works.
+func RemovedWorkString(w *RemovedWork) string {
+	status := "invisible"
+	if !w.RemovedAt.IsZero() {
+		status = "removed " + w.RemovedAt.Local().Format("2006-01-02")
+		if w.Post.SourceInvisible {
+			status += ", invisible"
+		}
+	}
+	owner := ""
+	if w.Post.Owner != nil && w.Post.Owner.UserDetail != nil {
+		owner = " by " + w.Post.Owner.UserDetail.Name
+	}
+	return fmt.Sprintf("[%s] %s %s %q%s", status, w.Post.Source, w.Post.SourceID, w.Title, owner)
+}
@@ -8,6 +8,8 @@ import (
 	"time"
 
 	"github.com/WOo0W/bowerbird/cli/log"
+	"github.com/WOo0W/bowerbird/helper/history"
+	"github.com/WOo0W/bowerbird/helper/novel"
 	"github.com/WOo0W/bowerbird/helper/orderedmap"
 	"github.com/WOo0W/bowerbird/model"
 	"github.com/WOo0W/go-pixiv/pixiv"
@@ -132,6 +134,13 @@ func saveUserProfile(ctx context.Context, cu, cud, cm *mongo.Collection, ru *pix
 	if err != nil {
 		return err
 	}
+	err = insertStatistic(ctx, cu.Database(), &model.Statistic{
+		UserID:    lookupObjectID(r),
+		Bookmarks: ru.Profile.TotalIllustBookmarksPublic,
+	})
+	if err != nil {
+		return err
+	}
 
 	err = updatePixivAvatars(ctx, cu, cm, uid, ru.User.ProfileImageURLs.Medium)
 	if err != nil {
@@ -184,7 +193,7 @@ func loadPixivTags(ctx context.Context, ct *mongo.Collection, tags []pixiv.Tag)
 	return oids, nil
 }
 
-func savePixivIllusts(ctx context.Context, ils []*pixiv.Illust, cu, cp, cpd, ct, cm *mongo.Collection, usersToUpdate map[int]struct{}) error {
+func savePixivIllusts(ctx context.Context, ils []*pixiv.Illust, ugoiras map[int]*ugoiraInfo, cu, cp, cpd, ct, cm, cc *mongo.Collection, usersToUpdate map[int]struct{}) error {
 	logger := log.FromContext(ctx)
 	for _, il := range ils {
 		sid := strconv.Itoa(il.ID)
@@ -235,30 +244,74 @@ func savePixivIllusts(ctx context.Context, ils []*pixiv.Illust, cu, cp, cpd, ct,
 				pd.MediaIDs = append(pd.MediaIDs, id)
 			}
 		}
+		if u, ok := ugoiras[il.ID]; ok {
+			id, err := insertUgoiraMedia(ctx, cm, u)
+			if err != nil {
+				return err
+			}
+			pd.Extension.PixivIllust.UgoiraMediaID = id
+		}
+
+		var seriesID primitive.ObjectID
+		if il.Series.ID != 0 {
+			var err error
+			seriesID, err = loadSeries(ctx, cc, model.CollectionSourcePixivIllustSeries, &il.Series)
+			if err != nil {
+				return err
+			}
+			pd.Extension.PixivIllust.SeriesID = seriesID
+		}
+
+		err := savePixivPostAndDetail(ctx, ct, cu, cm, cp, cpd, usersToUpdate, &il.User, p, pd, il.Tags)
+		if err != nil {
+			return err
+		}
 
-		savePixivPostAndDetail(ctx, ct, cu, cm, cp, cpd, usersToUpdate, &il.User, p, pd, il.Tags)
+		if !seriesID.IsZero() {
+			err := addPostToSeries(ctx, cp, cpd, cc, model.PostSourcePixivIllust, sid, seriesID)
+			if err != nil {
+				return err
+			}
+		}
 	}
 	return nil
 }
 
-func saveNovels(ctx context.Context, nos []*pixiv.Novel, cu, cp, cpd, ct, cm, cc *mongo.Collection, api *pixiv.AppAPI, usersToUpdate map[int]struct{}, processed, limit int, forceUpdateText bool) (int, error) {
+// saveNovels saves the novels with their text to database.
+// The novels not selected by filter are saved without fetching the text,
+// and their images are not downloaded. The novels with text saved
+// within 10 days are only updated unless forceUpdateText is true.
+// It returns processed plus the number of selected novels,
+// and stops when the number reaches limit.
+func saveNovels(ctx context.Context, nos []*pixiv.Novel, cu, cp, cpd, ct, cm, cc *mongo.Collection, api *pixiv.AppAPI, filter *Filter, usersToUpdate, series map[int]struct{}, owners *assetOwners, processed, limit int, forceUpdateText bool) (int, error) {
 	logger := log.FromContext(ctx)
 	for _, no := range nos {
 		if limit != 0 && processed >= limit {
 			return processed, nil
 		}
-		processed++
+		// n is 1 if the novel is counted as processed
+		n := 0
+		matched := filter.matchNovel(no)
+		if matched {
+			n = 1
+		}
+		processed += n
 
 		sid := strconv.Itoa(no.ID)
 		if !no.Visible {
 			logger.Warn("Skipped invisible item:", sid)
 			err := updateInvisiblePost(ctx, model.PostSourcePixivNovel, sid, cp)
 			if err != nil {
-				return processed - 1, err
+				return processed - n, err
 			}
 			continue
 		}
 
+		if matched {
+			owners.users[no.User.ID] = struct{}{}
+			owners.novels[no.ID] = struct{}{}
+		}
+
 		p := &model.Post{
 			Extension: &model.ExtPost{Pixiv: &model.PixivPost{
 				IsBookmarked:   no.IsBookmarked,
@@ -268,8 +321,11 @@ func saveNovels(ctx context.Context, nos []*pixiv.Novel, cu, cp, cpd, ct, cm, cc
 			Source:   model.PostSourcePixivNovel,
 			SourceID: sid,
 		}
+		if no.Series.ID != 0 && series != nil && matched {
+			series[no.Series.ID] = struct{}{}
+		}
 
-		if !forceUpdateText {
+		if !forceUpdateText || !matched {
 			r, err := cp.FindOne(ctx,
 				d{
 					{Key: "source", Value: model.PostSourcePixivNovel},
@@ -277,60 +333,196 @@ func saveNovels(ctx context.Context, nos []*pixiv.Novel, cu, cp, cpd, ct, cm, cc
 				}, options.FindOne().SetProjection(d{{Key: "lastModified", Value: true}})).DecodeBytes()
 			if err != nil {
 				if err != mongo.ErrNoDocuments {
-					return processed - 1, err
+					return processed - n, err
 				}
 			} else {
-				if t, ok := r.Lookup("lastModified").TimeOK(); ok && time.Since(t) < 240*time.Hour {
+				// the text saved before is kept for the novels not selected
+				if t, ok := r.Lookup("lastModified").TimeOK(); !matched || ok && time.Since(t) < 240*time.Hour {
 					err = beforeSavingPixivPost(ctx, ct, cu, cm, p, &no.User, usersToUpdate, no.Tags)
 					if err != nil {
-						return processed - 1, err
+						return processed - n, err
 					}
 
-					_, err = cp.UpdateOne(ctx,
+					r, err := cp.FindOneAndUpdate(ctx,
 						d{{Key: "source", Value: model.PostSourcePixivNovel},
 							{Key: "sourceID", Value: sid}},
 						d{{Key: "$set", Value: p},
-							{Key: "$currentDate", Value: d{{Key: "lastModified", Value: true}}}})
+							{Key: "$currentDate", Value: d{{Key: "lastModified", Value: true}}}},
+						optsFUIDOnly).DecodeBytes()
+					if err != nil {
+						return processed - n, err
+					}
+					err = insertPostStatistic(ctx, cp, lookupObjectID(r), p)
 					if err != nil {
-						return processed - 1, err
+						return processed - n, err
 					}
 					continue
 				}
 			}
 		}
 
-		logger.Info(fmt.Sprintf("Saving novel text: %s (%s)", no.Title, sid))
-		nod, err := api.Novel.Text(no.ID)
-		if err != nil {
-			logger.Error(err)
-			continue
-		}
-
 		pd := &model.PostDetail{
 			Extension: &model.ExtPostDetail{PixivNovel: &model.PixivNovelDetail{
 				CaptionHTML: no.Caption,
-				Text:        nod.NovelText,
 				Title:       no.Title,
 			}},
 			Date: no.CreateDate,
 		}
+		var doc *novel.Document
+		if matched {
+			logger.Info(fmt.Sprintf("Saving novel text: %s (%s)", no.Title, sid))
+			nod, err := api.Novel.Text(no.ID)
+			if err != nil {
+				logger.Error(err)
+				continue
+			}
+
+			doc = novel.Parse(nod.NovelText)
+			chapters := []model.NovelChapter{}
+			for _, c := range doc.Chapters() {
+				chapters = append(chapters, model.NovelChapter{Title: c.Title, Page: c.Page})
+			}
+			pd.Extension.PixivNovel.Text = nod.NovelText
+			pd.Extension.PixivNovel.Chapters = chapters
+			pd.Extension.PixivNovel.PageCount = len(doc.Pages)
+		}
+
+		if no.Series.ID != 0 {
+			var err error
+			pd.Extension.PixivNovel.SeriesID, err = loadSeries(ctx, cc, model.CollectionSourcePixivNovelSeries, &no.Series)
+			if err != nil {
+				return processed - n, err
+			}
+		}
 
 		if no.ImageURLs.Large != "" {
 			id, err := insertMediaWithURL(ctx, cm, model.MediaPixivNovelCover, no.ImageURLs.Large, 0, 0)
 			if err != nil {
-				return processed - 1, err
+				return processed - n, err
 			}
 			pd.MediaIDs = []primitive.ObjectID{id}
 		}
+		if doc != nil {
+			ids, err := insertNovelImageMedia(ctx, cm, fetchNovelImages(ctx, api, no.ID, doc))
+			if err != nil {
+				return processed - n, err
+			}
+			pd.MediaIDs = append(pd.MediaIDs, ids...)
+		}
 
-		err = savePixivPostAndDetail(ctx, ct, cu, cm, cp, cpd, usersToUpdate, &no.User, p, pd, no.Tags)
+		err := savePixivPostAndDetail(ctx, ct, cu, cm, cp, cpd, usersToUpdate, &no.User, p, pd, no.Tags)
 		if err != nil {
-			return processed - 1, err
+			return processed - n, err
 		}
 	}
 	return processed, nil
 }
 
+// loadSeries returns the ID of the Collection of series s.
+func loadSeries(ctx context.Context, cc *mongo.Collection, source model.CollectionSource, s *pixiv.Series) (primitive.ObjectID, error) {
+	r, err := cc.FindOneAndUpdate(ctx,
+		d{
+			{Key: "source", Value: source},
+			{Key: "sourceID", Value: strconv.Itoa(s.ID)},
+		},
+		d{{Key: "$set", Value: d{{Key: "name", Value: s.Title}}}},
+		optsFUIDOnly).DecodeBytes()
+	if err != nil {
+		return primitive.NilObjectID, err
+	}
+	return lookupObjectID(r), nil
+}
+
+// addPostToSeries adds the post with the saved detail to the Collection
+// of series if it is not in the series yet. The posts in the series are
+// kept in the order of creation, which is their order on pixiv.
+// The posts are updated only if they are not changed by others
+// in the meantime, and added again otherwise.
+func addPostToSeries(ctx context.Context, cp, cpd, cc *mongo.Collection, source model.PostSource, sid string, seriesID primitive.ObjectID) error {
+	r, err := cp.FindOne(ctx,
+		d{{Key: "source", Value: source}, {Key: "sourceID", Value: sid}},
+		options.FindOne().SetProjection(d{{Key: "_id", Value: 1}})).DecodeBytes()
+	if err != nil {
+		return err
+	}
+	id := lookupObjectID(r)
+
+	for {
+		added, err := addSeriesPostID(ctx, cpd, cc, seriesID, id)
+		if err != nil || added {
+			return err
+		}
+	}
+}
+
+// addSeriesPostID adds id to the posts of series in order, and reports false
+// if the posts are changed since they are read.
+func addSeriesPostID(ctx context.Context, cpd, cc *mongo.Collection, seriesID, id primitive.ObjectID) (bool, error) {
+	c := &model.Collection{}
+	err := cc.FindOne(ctx, d{{Key: "_id", Value: seriesID}},
+		options.FindOne().SetProjection(d{{Key: "postIDs", Value: 1}})).Decode(c)
+	if err != nil {
+		return false, err
+	}
+	for _, x := range c.PostIDs {
+		if x == id {
+			return true, nil
+		}
+	}
+	ids := make([]primitive.ObjectID, 0, len(c.PostIDs)+1)
+	ids = append(append(ids, c.PostIDs...), id)
+
+	cur, err := cpd.Find(ctx,
+		d{{Key: "postID", Value: d{{Key: "$in", Value: ids}}}},
+		options.Find().SetProjection(d{{Key: "postID", Value: 1}, {Key: "date", Value: 1}}))
+	if err != nil {
+		return false, err
+	}
+	pds := []model.PostDetail{}
+	if err := cur.All(ctx, &pds); err != nil {
+		return false, err
+	}
+	dates := make(map[primitive.ObjectID]time.Time, len(pds))
+	for _, pd := range pds {
+		dates[pd.PostID] = pd.Date
+	}
+	sort.SliceStable(ids, func(i, j int) bool {
+		return dates[ids[i]].Before(dates[ids[j]])
+	})
+
+	var prev interface{} = c.PostIDs
+	if len(c.PostIDs) == 0 {
+		// missing, null or empty
+		prev = d{{Key: "$in", Value: a{nil, a{}}}}
+	}
+	ur, err := cc.UpdateOne(ctx,
+		d{{Key: "_id", Value: seriesID}, {Key: "postIDs", Value: prev}},
+		d{{Key: "$set", Value: d{{Key: "postIDs", Value: ids}}}})
+	if err != nil {
+		return false, err
+	}
+	return ur.MatchedCount == 1, nil
+}
+
+// insertStatistic appends s dated now to the statistics in db.
+func insertStatistic(ctx context.Context, db *mongo.Database, s *model.Statistic) error {
+	s.Date = time.Now()
+	_, err := db.Collection(model.CollectionStatistic).InsertOne(ctx, s)
+	return err
+}
+
+// insertPostStatistic appends the totals of the pixiv post p saved with the ID.
+func insertPostStatistic(ctx context.Context, cp *mongo.Collection, id primitive.ObjectID, p *model.Post) error {
+	if p.Extension == nil || p.Extension.Pixiv == nil {
+		return nil
+	}
+	return insertStatistic(ctx, cp.Database(), &model.Statistic{
+		PostID:    id,
+		Bookmarks: p.Extension.Pixiv.TotalBookmarks,
+		Views:     p.Extension.Pixiv.TotalViews,
+	})
+}
+
 func updateInvisiblePost(ctx context.Context, source model.PostSource, sid string, cp *mongo.Collection) error {
 	_, err := cp.UpdateOne(
 		ctx,
@@ -366,12 +558,94 @@ func updatePostDetail(ctx context.Context, source model.PostSource, cp, cpd *mon
 	if err != nil {
 		return err
 	}
-	_, err = cpd.UpdateOne(ctx, pd,
-		d{{Key: "$set", Value: d{{Key: "postID", Value: lookupObjectID(r)}}}},
-		optsUUpsert)
+	err = insertPostStatistic(ctx, cp, lookupObjectID(r), p)
+	if err != nil {
+		return err
+	}
+	return savePostDetail(ctx, cpd, lookupObjectID(r), pd)
+}
+
+// derivedDetailFields are the fields of PostDetail derived from the others
+// or linking it to other documents, which are backfilled on the latest
+// version instead of saving a new one.
+var derivedDetailFields = map[string]struct{}{
+	"extension.pixivIllust.seriesID":      {},
+	"extension.pixivIllust.ugoiraMediaID": {},
+	"extension.pixivNovel.seriesID":       {},
+	"extension.pixivNovel.chapters":       {},
+	"extension.pixivNovel.pageCount":      {},
+}
+
+// savePostDetail saves pd as a new version of the detail of the post,
+// unless it differs from the latest version only in derived fields,
+// in which case the latest version is replaced with pd.
+func savePostDetail(ctx context.Context, cpd *mongo.Collection, postID primitive.ObjectID, pd *model.PostDetail) error {
+	setPostID := d{{Key: "$set", Value: d{{Key: "postID", Value: postID}}}}
+	r, err := cpd.UpdateOne(ctx, pd, setPostID)
+	if err != nil {
+		return err
+	}
+	if r.MatchedCount > 0 {
+		return nil
+	}
+
+	raw, err := cpd.FindOne(ctx, d{{Key: "postID", Value: postID}},
+		options.FindOne().SetSort(d{{Key: "_id", Value: -1}})).DecodeBytes()
+	if err != nil && err != mongo.ErrNoDocuments {
+		return err
+	}
+	if err == nil {
+		same, err := sameDetailVersion(raw, pd)
+		if err != nil {
+			return err
+		}
+		if same {
+			v := *pd
+			v.PostID = postID
+			_, err = cpd.ReplaceOne(ctx, d{{Key: "_id", Value: lookupObjectID(raw)}}, &v)
+			return err
+		}
+	}
+
+	_, err = cpd.UpdateOne(ctx, pd, setPostID, optsUUpsert)
 	return err
 }
 
+// sameDetailVersion reports whether pd differs from the saved detail
+// only in derived fields.
+func sameDetailVersion(saved bson.Raw, pd *model.PostDetail) (bool, error) {
+	old, cur := d{}, d{}
+	if err := bson.Unmarshal(saved, &old); err != nil {
+		return false, err
+	}
+	b, err := bson.Marshal(pd)
+	if err != nil {
+		return false, err
+	}
+	if err := bson.Unmarshal(b, &cur); err != nil {
+		return false, err
+	}
+	// the text of novels not selected by filter is not saved,
+	// so it is backfilled with the images in it
+	cs := history.Diff(old, cur)
+	textAdded := false
+	for _, c := range cs {
+		if c.Field == "extension.pixivNovel.text" && c.Old == nil {
+			textAdded = true
+		}
+	}
+	for _, c := range cs {
+		if _, ok := derivedDetailFields[c.Field]; ok {
+			continue
+		}
+		if textAdded && (c.Field == "extension.pixivNovel.text" || c.Field == "mediaIDs") {
+			continue
+		}
+		return false, nil
+	}
+	return true, nil
+}
+
 func savePixivPostAndDetail(ctx context.Context, ct, cu, cm, cp, cpd *mongo.Collection, usersToUpdate map[int]struct{}, pixivUser *pixiv.User, p *model.Post, pd *model.PostDetail, tags []pixiv.Tag) error {
 	err := beforeSavingPixivPost(ctx, ct, cu, cm, p, pixivUser, usersToUpdate, tags)
 	if err != nil {
@@ -0,0 +1,233 @@
+package pixiv
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/WOo0W/bowerbird/cli/log"
+	"github.com/WOo0W/bowerbird/helper/export"
+	"github.com/WOo0W/bowerbird/helper/novel"
+	"github.com/WOo0W/bowerbird/model"
+	"github.com/WOo0W/bowerbird/storage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	captionBreak = regexp.MustCompile(`(?i)<br\s*/?>`)
+	captionTag   = regexp.MustCompile(`<[^>]*>`)
+)
+
+// captionText converts the caption in HTML to plain text.
+func captionText(s string) string {
+	s = captionBreak.ReplaceAllString(s, "\n")
+	s = captionTag.ReplaceAllString(s, "")
+	return strings.TrimSpace(html.UnescapeString(s))
+}
+
+// pipelineNovelsWithOwner finds pixiv novels matching match
+// like pipelineIllustsWithOwner, with their tags.
+func pipelineNovelsWithOwner(match d) a {
+	p := pipelinePostsWithOwner(append(d{{Key: "source", Value: model.PostSourcePixivNovel}}, match...))
+	return append(p, d{{Key: "$lookup", Value: d{
+		{Key: "from", Value: model.CollectionTag},
+		{Key: "localField", Value: "tagIDs"},
+		{Key: "foreignField", Value: "_id"},
+		{Key: "as", Value: "tags"},
+	}}})
+}
+
+// novelImageTypes maps the media types of images in novels to the markers.
+var novelImageTypes = map[model.MediaType]string{
+	model.MediaPixivNovelImage:  "uploadedimage",
+	model.MediaPixivNovelIllust: "pixivimage",
+}
+
+// exportNovel is a novel loaded for exporting.
+type exportNovel struct {
+	id  primitive.ObjectID
+	sid string
+	*export.Novel
+}
+
+// exportNovelDetail returns the detail of novel p to export,
+// or nil if its text is not saved, like the novels not selected by filter.
+func exportNovelDetail(p *model.Post) *model.PixivNovelDetail {
+	pd := p.PostDetail
+	if pd == nil || pd.Extension == nil || pd.Extension.PixivNovel == nil || pd.Extension.PixivNovel.Text == "" {
+		return nil
+	}
+	return pd.Extension.PixivNovel
+}
+
+// loadExportNovels loads the novels matching match for exporting,
+// with their media files read from s, in the order of creation.
+func loadExportNovels(ctx context.Context, db *mongo.Database, s storage.Storage, match d) ([]exportNovel, error) {
+	logger := log.FromContext(ctx)
+	cm := db.Collection(model.CollectionMedia)
+	cur, err := db.Collection(model.CollectionPost).Aggregate(ctx, pipelineNovelsWithOwner(match))
+	if err != nil {
+		return nil, err
+	}
+	ps := []*model.Post{}
+	if err := cur.All(ctx, &ps); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(ps, func(i, j int) bool {
+		return ps[i].PostDetail != nil && ps[j].PostDetail != nil &&
+			ps[i].PostDetail.Date.Before(ps[j].PostDetail.Date)
+	})
+
+	nos := []exportNovel{}
+	for _, p := range ps {
+		nd := exportNovelDetail(p)
+		if nd == nil {
+			logger.Warn(fmt.Sprintf("Skipped novel %s without text", p.SourceID))
+			continue
+		}
+		pd := p.PostDetail
+		no := &export.Novel{
+			Title:   nd.Title,
+			URL:     "https://www.pixiv.net/novel/show.php?id=" + p.SourceID,
+			Date:    pd.Date,
+			Caption: captionText(nd.CaptionHTML),
+			Doc:     novel.Parse(nd.Text),
+			Images:  make(map[novel.Image]*export.Image),
+		}
+		if p.Owner != nil && p.Owner.UserDetail != nil {
+			no.Author = p.Owner.UserDetail.Name
+		}
+		for _, t := range p.Tags {
+			if len(t.Alias) != 0 {
+				no.Tags = append(no.Tags, t.Alias[0])
+			}
+		}
+
+		mcur, err := cm.Find(ctx, d{{Key: "_id", Value: d{{Key: "$in", Value: pd.MediaIDs}}}})
+		if err != nil {
+			return nil, err
+		}
+		ms := []model.Media{}
+		if err := mcur.All(ctx, &ms); err != nil {
+			return nil, err
+		}
+		for _, m := range ms {
+			if m.Path == "" {
+				logger.Warn(fmt.Sprintf("Media %s of novel %s is not downloaded", m.ID.Hex(), p.SourceID))
+				continue
+			}
+			im, err := readExportImage(ctx, s, m.Path)
+			if err != nil {
+				logger.Error(fmt.Sprintf("Reading %q: %s", m.Path, err))
+				continue
+			}
+			if m.Type == model.MediaPixivNovelCover {
+				no.Cover = im
+			} else if t, ok := novelImageTypes[m.Type]; ok && m.Extension != nil && m.Extension.Pixiv != nil {
+				no.Images[novel.Image{Type: t, ID: m.Extension.Pixiv.NovelImage}] = im
+			}
+		}
+		nos = append(nos, exportNovel{id: p.ID, sid: p.SourceID, Novel: no})
+	}
+	return nos, nil
+}
+
+func readExportImage(ctx context.Context, s storage.Storage, name string) (*export.Image, error) {
+	rc, err := s.Open(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	b, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	}
+	return &export.Image{Name: path.Base(name), Data: b}, nil
+}
+
+func novelBook(no exportNovel) *export.Book {
+	return &export.Book{
+		ID:     "urn:pixiv:novel:" + no.sid,
+		Title:  no.Title,
+		Author: no.Author,
+		Cover:  no.Cover,
+		Novels: []*export.Novel{no.Novel},
+	}
+}
+
+// NovelBook loads the pixiv novel with the ID from database as a Book,
+// with the media files read from s.
+func NovelBook(ctx context.Context, db *mongo.Database, s storage.Storage, novelID string) (*export.Book, error) {
+	nos, err := loadExportNovels(ctx, db, s, d{{Key: "sourceID", Value: novelID}})
+	if err != nil {
+		return nil, err
+	}
+	if len(nos) == 0 {
+		return nil, fmt.Errorf("novel %s is not found in database", novelID)
+	}
+	return novelBook(nos[0]), nil
+}
+
+// NovelSeriesBook loads the novels in the Collection of pixiv novel series
+// from database as a Book in the order of creation, which is the order
+// of the series on pixiv.
+func NovelSeriesBook(ctx context.Context, db *mongo.Database, s storage.Storage, seriesID string) (*export.Book, error) {
+	c := &model.Collection{}
+	err := db.Collection(model.CollectionCollection).FindOne(ctx, d{
+		{Key: "source", Value: model.CollectionSourcePixivNovelSeries},
+		{Key: "sourceID", Value: seriesID},
+	}).Decode(c)
+	if err == mongo.ErrNoDocuments {
+		return nil, fmt.Errorf("novel series %s is not found in database", seriesID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	nos, err := loadExportNovels(ctx, db, s, d{{Key: "_id", Value: d{{Key: "$in", Value: c.PostIDs}}}})
+	if err != nil {
+		return nil, err
+	}
+	if len(nos) == 0 {
+		return nil, fmt.Errorf("no novel of series %s is found in database", seriesID)
+	}
+	b := novelBook(nos[0])
+	b.ID = "urn:pixiv:novel-series:" + seriesID
+	b.Title = c.Name
+	b.Novels = make([]*export.Novel, 0, len(nos))
+	for _, no := range nos {
+		b.Novels = append(b.Novels, no.Novel)
+	}
+	return b, nil
+}
+
+// UserNovelBooks loads every novel of the pixiv user from database,
+// each as a Book.
+func UserNovelBooks(ctx context.Context, db *mongo.Database, s storage.Storage, userID string) ([]*export.Book, error) {
+	r, err := db.Collection(model.CollectionUser).FindOne(ctx, d{
+		{Key: "source", Value: model.SourcePixiv},
+		{Key: "sourceID", Value: userID},
+	}).DecodeBytes()
+	if err == mongo.ErrNoDocuments {
+		return nil, fmt.Errorf("user %s is not found in database", userID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	nos, err := loadExportNovels(ctx, db, s, d{{Key: "ownerID", Value: lookupObjectID(r)}})
+	if err != nil {
+		return nil, err
+	}
+	bs := make([]*export.Book, 0, len(nos))
+	for _, no := range nos {
+		bs = append(bs, novelBook(no))
+	}
+	return bs, nil
+}
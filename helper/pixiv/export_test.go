@@ -0,0 +1,26 @@
+package pixiv
+
+import (
+	"testing"
+
+	"github.com/WOo0W/bowerbird/model"
+)
+
+func TestExportNovelDetail(t *testing.T) {
+	novel := func(text string) *model.Post {
+		return &model.Post{PostDetail: &model.PostDetail{
+			Extension: &model.ExtPostDetail{PixivNovel: &model.PixivNovelDetail{Title: "title", Text: text}},
+		}}
+	}
+	if nd := exportNovelDetail(novel("text")); nd == nil || nd.Text != "text" {
+		t.Errorf("novel with text not exported: %+v", nd)
+	}
+	for name, p := range map[string]*model.Post{
+		"without detail": {},
+		"without text":   novel(""),
+	} {
+		if nd := exportNovelDetail(p); nd != nil {
+			t.Errorf("novel %s exported: %+v", name, nd)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package pixiv
+
+import (
+	"github.com/WOo0W/bowerbird/helper/filter"
+	"github.com/WOo0W/go-pixiv/pixiv"
+)
+
+// Filter selects the works to download or save.
+// A nil Filter selects every work.
+type Filter struct {
+	// Tags selects the works with any of the tags,
+	// or all of them if TagsMatchAll is true.
+	Tags         []string
+	TagsMatchAll bool
+	// MinBookmarks selects the works bookmarked at least MinBookmarks times.
+	MinBookmarks int
+	// Expr selects the works matching the expression if it is not nil.
+	Expr *filter.Expr
+}
+
+// xRestricts maps the x_restrict of works to the restrict in expressions.
+var xRestricts = []string{"all-ages", "R-18", "R-18G"}
+
+func filterWork(typ string, u *pixiv.User, tags []pixiv.Tag, w *filter.Work) *filter.Work {
+	w.Type = typ
+	w.UserID = u.ID
+	w.Tags = make([]string, 0, len(tags)*2)
+	for _, t := range tags {
+		w.Tags = append(w.Tags, t.Name)
+		if t.TranslatedName != "" {
+			w.Tags = append(w.Tags, t.TranslatedName)
+		}
+	}
+	return w
+}
+
+func xRestrict(x int) string {
+	if x >= 0 && x < len(xRestricts) {
+		return xRestricts[x]
+	}
+	return ""
+}
+
+func (f *Filter) match(tags []pixiv.Tag, bookmarks int, w func() *filter.Work) bool {
+	if f == nil {
+		return true
+	}
+	if len(f.Tags) != 0 {
+		if f.TagsMatchAll {
+			if !hasEveryTag(tags, f.Tags...) {
+				return false
+			}
+		} else if !hasAnyTag(tags, f.Tags...) {
+			return false
+		}
+	}
+	if bookmarks < f.MinBookmarks {
+		return false
+	}
+	return f.Expr == nil || f.Expr.Match(w())
+}
+
+func (f *Filter) matchIllust(il *pixiv.Illust) bool {
+	return f.match(il.Tags, il.TotalBookmarks, func() *filter.Work {
+		return filterWork(il.Type, &il.User, il.Tags, &filter.Work{
+			Pages:     il.PageCount,
+			Views:     il.TotalView,
+			Bookmarks: il.TotalBookmarks,
+			Restrict:  xRestrict(il.XRestrict),
+			Date:      il.CreateDate,
+		})
+	})
+}
+
+func (f *Filter) matchNovel(no *pixiv.Novel) bool {
+	return f.match(no.Tags, no.TotalBookmarks, func() *filter.Work {
+		return filterWork("novel", &no.User, no.Tags, &filter.Work{
+			Pages:     no.PageCount,
+			Views:     no.TotalView,
+			Bookmarks: no.TotalBookmarks,
+			Restrict:  xRestrict(no.XRestrict),
+			Date:      no.CreateDate,
+		})
+	})
+}
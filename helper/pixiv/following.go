@@ -0,0 +1,110 @@
+package pixiv
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/WOo0W/bowerbird/cli/log"
+	"github.com/WOo0W/bowerbird/downloader"
+	"github.com/WOo0W/bowerbird/model"
+	"github.com/WOo0W/go-pixiv/pixiv"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// saveFollowing saves the users followed by the logged user to database
+// and returns their IDs.
+// The users no longer followed are marked as not followed.
+func saveFollowing(ctx context.Context, api *pixiv.AppAPI, cu, cud, cm *mongo.Collection) ([]int, error) {
+	logger := log.FromContext(ctx)
+	usersToUpdate := make(map[int]struct{})
+	ids := []int{}
+	sids := a{}
+	for _, restrict := range []pixiv.Restrict{pixiv.RPublic, pixiv.RPrivate} {
+		r, err := api.User.Followings(api.UserID, &pixiv.FollowingQuery{Restrict: restrict})
+		for {
+			if err != nil {
+				return nil, err
+			}
+			for _, up := range r.UserPreviews {
+				u := &up.User
+				_, err := loadPixivUser(ctx, cu, usersToUpdate, u.ID, true, 240*time.Hour)
+				if err != nil {
+					return nil, err
+				}
+				sid := strconv.Itoa(u.ID)
+				err = updatePixivAvatars(ctx, cu, cm, sid, u.ProfileImageURLs.Medium)
+				if err != nil {
+					return nil, err
+				}
+				ids = append(ids, u.ID)
+				sids = append(sids, sid)
+			}
+			logger.Info(len(ids), "followed users saved")
+			if r.NextURL == "" {
+				break
+			}
+			r, err = r.NextFollowing()
+		}
+	}
+
+	ur, err := cu.UpdateMany(ctx,
+		d{
+			{Key: "source", Value: model.SourcePixiv},
+			{Key: "extension.pixiv.isFollowed", Value: true},
+			{Key: "sourceID", Value: d{{Key: "$nin", Value: sids}}},
+		},
+		d{{Key: "$set", Value: d{{Key: "extension.pixiv.isFollowed", Value: false}}}})
+	if err != nil {
+		return nil, err
+	}
+	if ur.ModifiedCount > 0 {
+		logger.Info(ur.ModifiedCount, "users are no longer followed")
+	}
+
+	updateUserSet(ctx, cu, cud, cm, api, usersToUpdate)
+	return ids, nil
+}
+
+// SyncFollowing saves the users followed by the logged user to database,
+// and processes the uploaded illusts of each of them incrementally,
+// stopping at stop consecutive works already archived.
+// The limit applies to each user.
+func SyncFollowing(ctx context.Context, limit, stop int, dl *downloader.Downloader, api *pixiv.AppAPI, opts *DownloadOptions, filter *Filter, db *mongo.Database, dbOnly bool) error {
+	logger := log.FromContext(ctx)
+	ids, err := saveFollowing(ctx, api,
+		db.Collection(model.CollectionUser),
+		db.Collection(model.CollectionUserDetail),
+		db.Collection(model.CollectionMedia))
+	if err != nil {
+		return err
+	}
+
+	for i, id := range ids {
+		logger.Info(fmt.Sprintf("[%d/%d] Syncing the uploads of user %d", i+1, len(ids), id))
+		inc := &Incremental{
+			Stop: stop,
+			Checkpoint: &model.Checkpoint{
+				Source: model.SourcePixiv,
+				Feed:   "illust-uploads",
+				UserID: strconv.Itoa(id),
+			},
+		}
+		var opt *pixiv.IllustQuery
+		ok, err := LoadCheckpoint(ctx, db, inc.Checkpoint)
+		if err != nil {
+			return err
+		}
+		if ok && inc.Checkpoint.Offset > 0 {
+			opt = &pixiv.IllustQuery{Offset: inc.Checkpoint.Offset}
+		}
+		ri, err := api.User.Illusts(id, opt)
+		if err != nil {
+			logger.Error(err)
+			continue
+		}
+		ProcessIllusts(ctx, ri, limit, dl, api, opts, filter, inc, nil, db, dbOnly)
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package pixiv
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+
+	"github.com/WOo0W/bowerbird/model"
+	"github.com/WOo0W/go-pixiv/pixiv"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Incremental enables the incremental crawling of a feed.
+type Incremental struct {
+	// Stop is the number of consecutive works already archived
+	// to stop paginating at. Zero never stops.
+	Stop int
+	// Checkpoint is the feed saved to database with the query
+	// of the page where the crawling stopped, if not nil.
+	Checkpoint *model.Checkpoint
+}
+
+func checkpointFilter(ck *model.Checkpoint) d {
+	return d{
+		{Key: "source", Value: model.SourcePixiv},
+		{Key: "feed", Value: ck.Feed},
+		{Key: "userID", Value: ck.UserID},
+		{Key: "restrict", Value: ck.Restrict},
+	}
+}
+
+// LoadCheckpoint sets the query of the page in ck
+// where the last crawling of the feed stopped.
+// It returns false if the last crawling finished.
+func LoadCheckpoint(ctx context.Context, db *mongo.Database, ck *model.Checkpoint) (bool, error) {
+	err := db.Collection(model.CollectionCheckpoint).
+		FindOne(ctx, checkpointFilter(ck)).Decode(ck)
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return ck.MaxBookmarkID != 0 || ck.Offset != 0, nil
+}
+
+// saveCheckpoint saves the query of the page with nextURL to ck.
+// The checkpoint is removed if nextURL is empty.
+func saveCheckpoint(ctx context.Context, cck *mongo.Collection, ck *model.Checkpoint, nextURL string) error {
+	if nextURL == "" {
+		_, err := cck.DeleteOne(ctx, checkpointFilter(ck))
+		return err
+	}
+	u, err := url.Parse(nextURL)
+	if err != nil {
+		return err
+	}
+	q := u.Query()
+	ck.MaxBookmarkID, _ = strconv.Atoi(q.Get("max_bookmark_id"))
+	ck.Offset, _ = strconv.Atoi(q.Get("offset"))
+	_, err = cck.UpdateOne(ctx, checkpointFilter(ck), d{
+		{Key: "$set", Value: d{
+			{Key: "maxBookmarkID", Value: ck.MaxBookmarkID},
+			{Key: "offset", Value: ck.Offset},
+		}},
+		{Key: "$currentDate", Value: d{{Key: "lastModified", Value: true}}},
+	}, optsUUpsert)
+	return err
+}
+
+// illustURLs returns the URLs of original images of il.
+func illustURLs(il *pixiv.Illust) []string {
+	if il.MetaSinglePage.OriginalImageURL != "" {
+		return []string{il.MetaSinglePage.OriginalImageURL}
+	}
+	us := make([]string, 0, len(il.MetaPages))
+	for _, p := range il.MetaPages {
+		us = append(us, p.ImageURLs.Original)
+	}
+	return us
+}
+
+// archivedIllusts returns the IDs of illusts in ils which are in database.
+// If checkMedia is true, all their images should be downloaded as well.
+func archivedIllusts(ctx context.Context, cp, cm *mongo.Collection, ils []*pixiv.Illust, checkMedia bool) (map[int]bool, error) {
+	ids := make(a, 0, len(ils))
+	urls := a{}
+	for _, il := range ils {
+		ids = append(ids, strconv.Itoa(il.ID))
+		for _, u := range illustURLs(il) {
+			urls = append(urls, u)
+		}
+	}
+
+	cur, err := cp.Find(ctx, d{
+		{Key: "source", Value: model.PostSourcePixivIllust},
+		{Key: "sourceID", Value: d{{Key: "$in", Value: ids}}},
+	}, options.Find().SetProjection(d{{Key: "sourceID", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	posts := make(map[string]struct{})
+	for cur.Next(ctx) {
+		posts[cur.Current.Lookup("sourceID").StringValue()] = struct{}{}
+	}
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+
+	downloaded := make(map[string]struct{})
+	if checkMedia && len(urls) != 0 {
+		cur, err = cm.Find(ctx, d{
+			{Key: "url", Value: d{{Key: "$in", Value: urls}}},
+			{Key: "path", Value: d{{Key: "$exists", Value: true}}},
+		}, options.Find().SetProjection(d{{Key: "url", Value: 1}}))
+		if err != nil {
+			return nil, err
+		}
+		for cur.Next(ctx) {
+			downloaded[cur.Current.Lookup("url").StringValue()] = struct{}{}
+		}
+		if err := cur.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	r := make(map[int]bool, len(ils))
+	for _, il := range ils {
+		if _, ok := posts[strconv.Itoa(il.ID)]; !ok {
+			continue
+		}
+		ok := true
+		if checkMedia {
+			for _, u := range illustURLs(il) {
+				if _, has := downloaded[u]; !has {
+					ok = false
+					break
+				}
+			}
+		}
+		r[il.ID] = ok
+	}
+	return r, nil
+}
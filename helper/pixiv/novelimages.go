@@ -0,0 +1,146 @@
+package pixiv
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/WOo0W/bowerbird/cli/log"
+	"github.com/WOo0W/bowerbird/helper/novel"
+	"github.com/WOo0W/bowerbird/model"
+	"github.com/WOo0W/go-pixiv/pixiv"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// novelImage is an image embedded in novel text.
+type novelImage struct {
+	Type model.MediaType
+	// Key is the ID in the marker, like "12345" or "67890-2".
+	Key string
+	URL string
+}
+
+// respNovelAjax is the part of the response from:
+//
+//  https://www.pixiv.net/ajax/novel/...
+type respNovelAjax struct {
+	Error   bool   `json:"error"`
+	Message string `json:"message"`
+	Body    struct {
+		TextEmbeddedImages map[string]struct {
+			URLs struct {
+				Original string `json:"original"`
+			} `json:"urls"`
+		} `json:"textEmbeddedImages"`
+	} `json:"body"`
+}
+
+// uploadedImageURLs returns the original URLs of the images uploaded to the novel
+// by their IDs. They are not in App-API, so the web API is used.
+func uploadedImageURLs(api *pixiv.AppAPI, novelID int) (map[string]string, error) {
+	req, err := http.NewRequest("GET", "https://www.pixiv.net/ajax/novel/"+strconv.Itoa(novelID), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Referer", "https://www.pixiv.net/")
+	resp, err := api.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	r := &respNovelAjax{}
+	if err := json.NewDecoder(resp.Body).Decode(r); err != nil {
+		return nil, fmt.Errorf("GET %s: HTTP %s: %w", req.URL.Path, resp.Status, err)
+	}
+	if r.Error {
+		return nil, errors.New(r.Message)
+	}
+	urls := make(map[string]string, len(r.Body.TextEmbeddedImages))
+	for id, im := range r.Body.TextEmbeddedImages {
+		urls[id] = im.URLs.Original
+	}
+	return urls, nil
+}
+
+// illustPageURL returns the original URL of the page of the illust, starting from 1.
+func illustPageURL(api *pixiv.AppAPI, illustID, page int) (string, error) {
+	r, err := api.Illust.Detail(illustID)
+	if err != nil {
+		return "", err
+	}
+	il := &r.Illust
+	if len(il.MetaPages) == 0 {
+		if page == 1 {
+			return il.MetaSinglePage.OriginalImageURL, nil
+		}
+	} else if page >= 1 && page <= len(il.MetaPages) {
+		return il.MetaPages[page-1].ImageURLs.Original, nil
+	}
+	return "", fmt.Errorf("illust %d has no page %d", illustID, page)
+}
+
+// fetchNovelImages returns the images embedded in the novel
+// in the order of their first markers.
+// The images failed to resolve are logged and skipped.
+func fetchNovelImages(ctx context.Context, api *pixiv.AppAPI, novelID int, doc *novel.Document) []novelImage {
+	logger := log.FromContext(ctx)
+	var uploaded map[string]string
+	ims := []novelImage{}
+	for _, nim := range doc.Images() {
+		im := novelImage{Key: nim.ID}
+		if nim.Type == "uploadedimage" {
+			if uploaded == nil {
+				var err error
+				uploaded, err = uploadedImageURLs(api, novelID)
+				if err != nil {
+					logger.Warn(fmt.Sprintf("Getting the images of novel %d: %s", novelID, err))
+					uploaded = map[string]string{}
+				}
+			}
+			im.Type = model.MediaPixivNovelImage
+			im.URL = uploaded[nim.ID]
+		} else {
+			im.Type = model.MediaPixivNovelIllust
+			s := strings.SplitN(nim.ID, "-", 2)
+			id, _ := strconv.Atoi(s[0])
+			page, _ := strconv.Atoi(s[1])
+			u, err := illustPageURL(api, id, page)
+			if err != nil {
+				logger.Warn(fmt.Sprintf("Getting the image %s of novel %d: %s", nim.ID, novelID, err))
+				continue
+			}
+			im.URL = u
+		}
+		if im.URL == "" {
+			logger.Warn(fmt.Sprintf("Image %s:%s of novel %d is not found", nim.Type, nim.ID, novelID))
+			continue
+		}
+		ims = append(ims, im)
+	}
+	return ims
+}
+
+// insertNovelImageMedia saves the images embedded in novel text as Media
+// and returns their IDs.
+func insertNovelImageMedia(ctx context.Context, cm *mongo.Collection, ims []novelImage) ([]primitive.ObjectID, error) {
+	ids := make([]primitive.ObjectID, 0, len(ims))
+	for _, im := range ims {
+		r, err := cm.FindOneAndUpdate(ctx,
+			d{{Key: "url", Value: im.URL}},
+			d{{Key: "$set", Value: d{
+				{Key: "type", Value: im.Type},
+				{Key: "extension.pixiv.novelImage", Value: im.Key},
+			}}},
+			optsFUIDOnly).DecodeBytes()
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, lookupObjectID(r))
+	}
+	return ids, nil
+}
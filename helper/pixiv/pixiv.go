@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
+	"path"
 	"path/filepath"
 	"regexp"
 	"sort"
@@ -13,7 +14,9 @@ import (
 	"time"
 
 	"github.com/WOo0W/bowerbird/cli/log"
+	"github.com/WOo0W/bowerbird/config"
 	"github.com/WOo0W/bowerbird/downloader"
+	"github.com/WOo0W/bowerbird/helper/ugoira"
 	"github.com/WOo0W/bowerbird/model"
 	"github.com/WOo0W/go-pixiv/pixiv"
 	"go.mongodb.org/mongo-driver/bson"
@@ -49,20 +52,103 @@ var (
 	// )
 )
 
-// pximgSingleFileWithDate returns path like `123/27427531_p0_20120522161622.png`.
-// date: string like 2012/05/22/16/16/22
-func pximgSingleFileWithDate(userID int, u *url.URL) string {
-	fn := filepath.Base(u.Path)
-	i := strings.LastIndexByte(fn, '.')
-	return strconv.Itoa(userID) +
-		"/" + fn[:i] + "_" + strings.ReplaceAll(PximgDate.FindString(u.Path), "/", "") + fn[i:]
+// PathTemplates are the parsed templates of paths of pixiv files.
+type PathTemplates struct {
+	Illust      *downloader.PathTemplate
+	IllustPages *downloader.PathTemplate
+}
+
+// ParsePathTemplates parses the templates of pixiv in c.
+func ParsePathTemplates(c *config.PathTemplatesConfig) (*PathTemplates, error) {
+	pt := &PathTemplates{}
+	var err error
+	pt.Illust, err = downloader.ParsePathTemplate(c.PixivIllust)
+	if err != nil {
+		return nil, err
+	}
+	if c.PixivIllustPages != "" {
+		pt.IllustPages, err = downloader.ParsePathTemplate(c.PixivIllustPages)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return pt, nil
+}
+
+// illustFields returns the placeholders of the illust in path templates.
+func illustFields(userID, userName, userAccount, id, title, typ string) map[string]string {
+	return map[string]string{
+		"user.id":      userID,
+		"user.name":    userName,
+		"user.account": userAccount,
+		"illust.id":    id,
+		"illust.title": title,
+		"illust.type":  typ,
+	}
+}
+
+// illustPath returns the path of the page of illust with original image URL u,
+// like `123/27427531_p0_20120522161622.png` with the default templates.
+func (pt *PathTemplates) illustPath(fields map[string]string, pages, page int, u string) (string, error) {
+	uu, err := url.Parse(u)
+	if err != nil {
+		return "", err
+	}
+	fn := path.Base(uu.Path)
+	ext := path.Ext(fn)
+
+	f := make(map[string]string, len(fields)+4)
+	for k, v := range fields {
+		f[k] = v
+	}
+	// date: string like 2012/05/22/16/16/22
+	f["date"] = strings.ReplaceAll(PximgDate.FindString(uu.Path), "/", "")
+	f["page"] = strconv.Itoa(page)
+	f["file"] = strings.TrimSuffix(fn, ext)
+	f["ext"] = ext
+
+	t := pt.Illust
+	if pages > 1 && pt.IllustPages != nil {
+		t = pt.IllustPages
+	}
+	return t.Execute(f)
+}
+
+// taskGroup returns the group of download tasks
+// of a post, like `pixiv-illust/12345`.
+func taskGroup(source model.PostSource, id int) string {
+	return string(source) + "/" + strconv.Itoa(id)
 }
 
 func setAfterFinishedFunc(ctx context.Context, cm *mongo.Collection, t *downloader.Task, u, fp string) {
-	t.AfterFinished = func(*downloader.Task) {
+	t.AfterFinished = func(t *downloader.Task) {
+		set := bson.D{{Key: "path", Value: fp}}
+		if t.SHA256 != "" {
+			set = append(set,
+				bson.E{Key: "sha256", Value: t.SHA256},
+				bson.E{Key: "size", Value: t.Size},
+				bson.E{Key: "mime", Value: t.MIME},
+			)
+		}
+		_, err := cm.UpdateOne(ctx,
+			bson.D{{Key: "url", Value: u}},
+			bson.D{{Key: "$set", Value: set}})
+		if err != nil {
+			log.FromContext(ctx).Error(err)
+		}
+	}
+}
+
+// setAfterFailedFunc sets the hook marking the media with URL u as gone
+// if t fails with the file removed from pixiv.
+func setAfterFailedFunc(ctx context.Context, cm *mongo.Collection, t *downloader.Task, u string) {
+	t.AfterFailed = func(t *downloader.Task) {
+		if !downloader.IsGone(t.Err) {
+			return
+		}
 		_, err := cm.UpdateOne(ctx,
 			bson.D{{Key: "url", Value: u}},
-			bson.D{{Key: "$set", Value: bson.D{{Key: "path", Value: fp}}}})
+			bson.D{{Key: "$set", Value: bson.D{{Key: "gone", Value: true}}}})
 		if err != nil {
 			log.FromContext(ctx).Error(err)
 		}
@@ -141,8 +227,9 @@ func updatePixivUserProfiles(ctx context.Context, cu, cud, cm *mongo.Collection,
 // UpdateAllUsers updates the pixiv user in database.
 // If forceAll is true it updates all pixiv users,
 // otherwise it updates the users whose lastModified
-// is before now - before
-func UpdateAllUsers(ctx context.Context, db *mongo.Database, api *pixiv.AppAPI, forceAll bool, before time.Duration) error {
+// is before now - before.
+// If dl is not nil, the images of user profiles are downloaded under basePath.
+func UpdateAllUsers(ctx context.Context, db *mongo.Database, api *pixiv.AppAPI, dl *downloader.Downloader, basePath string, forceAll bool, before time.Duration) error {
 	cu := db.Collection(model.CollectionUser)
 	cud := db.Collection(model.CollectionUserDetail)
 	cm := db.Collection(model.CollectionMedia)
@@ -174,9 +261,53 @@ func UpdateAllUsers(ctx context.Context, db *mongo.Database, api *pixiv.AppAPI,
 		ids = append(ids, idInt)
 	}
 	updatePixivUserProfiles(ctx, cu, cud, cm, api, ids)
+	if dl != nil {
+		owners := newAssetOwners()
+		for _, id := range ids {
+			owners.users[id] = struct{}{}
+		}
+		n, err := queueAssets(ctx, db, dl, basePath, userAssetTypes, owners)
+		if err != nil {
+			return err
+		}
+		log.FromContext(ctx).Info(n, "user profile images were sent to download queue")
+	}
 	return nil
 }
 
+// ResumeTasks adds the unfinished tasks in the journal of dl to the queue.
+// If db is not nil, the path of media under basePath will be updated
+// when the tasks finish.
+func ResumeTasks(ctx context.Context, dl *downloader.Downloader, basePath string, db *mongo.Database) (int, error) {
+	ts, err := dl.Journal.Tasks()
+	if err != nil {
+		return 0, err
+	}
+	var cm *mongo.Collection
+	if db != nil {
+		cm = db.Collection(model.CollectionMedia)
+	}
+	added := make(map[int64]struct{})
+	for _, t := range dl.TaskList() {
+		added[t.ID] = struct{}{}
+	}
+	n := 0
+	for _, t := range ts {
+		if _, ok := added[t.ID]; ok {
+			continue
+		}
+		n++
+		if cm != nil {
+			if fp, err := filepath.Rel(basePath, t.LocalPath); err == nil && !strings.HasPrefix(fp, "..") {
+				setAfterFinishedFunc(ctx, cm, t, t.Request.URL.String(), filepath.ToSlash(fp))
+				setAfterFailedFunc(ctx, cm, t, t.Request.URL.String())
+			}
+		}
+		dl.Add(t)
+	}
+	return n, nil
+}
+
 func newPximgRequest(url string) (*http.Request, error) {
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
@@ -186,16 +317,39 @@ func newPximgRequest(url string) (*http.Request, error) {
 	return req, nil
 }
 
+// DownloadOptions defines how the files of pixiv works are downloaded.
+type DownloadOptions struct {
+	// BasePath is the local directory the files are downloaded to.
+	BasePath string
+	Paths    *PathTemplates
+	// Ugoira converts the downloaded ugoira zips if not nil.
+	Ugoira *ugoira.Converter
+
+	ugoiras ugoiraQueue
+}
+
+// WaitUgoira waits for the downloaded ugoira zips to be converted.
+func (o *DownloadOptions) WaitUgoira() {
+	if o != nil {
+		o.ugoiras.wg.Wait()
+	}
+}
+
 // ProcessIllusts processes the pixiv illusts until
-// the NextURL is empty or the limit reached
-func ProcessIllusts(ctx context.Context, ri *pixiv.RespIllusts, limit int, dl *downloader.Downloader, api *pixiv.AppAPI, basePath string, tags []string, tagsMatchAll bool, db *mongo.Database, dbOnly bool) {
+// the NextURL is empty or the limit reached.
+// Only the works selected by filter are downloaded.
+// If inc is not nil and db is not nil, it stops
+// at the works already archived.
+// If bs is not nil and db is not nil, the works are recorded as bookmarks.
+func ProcessIllusts(ctx context.Context, ri *pixiv.RespIllusts, limit int, dl *downloader.Downloader, api *pixiv.AppAPI, opts *DownloadOptions, filter *Filter, inc *Incremental, bs *BookmarkSync, db *mongo.Database, dbOnly bool) {
 	i := 0
 	idb := 0
 	usersToUpdate := make(map[int]struct{})
+	owners := newAssetOwners()
 
 	logger := log.FromContext(ctx)
 
-	var cu, cp, cpd, ct, cm, cud *mongo.Collection
+	var cu, cp, cpd, ct, cm, cc, cud, cb *mongo.Collection
 	if db != nil {
 		cu = db.Collection(model.CollectionUser)
 		cud = db.Collection(model.CollectionUserDetail)
@@ -203,17 +357,60 @@ func ProcessIllusts(ctx context.Context, ri *pixiv.RespIllusts, limit int, dl *d
 		cpd = db.Collection(model.CollectionPostDetail)
 		ct = db.Collection(model.CollectionTag)
 		cm = db.Collection(model.CollectionMedia)
+		cc = db.Collection(model.CollectionCollection)
+		cb = db.Collection(model.CollectionBookmark)
+	}
+	var cck *mongo.Collection
+	if inc != nil && inc.Checkpoint != nil && db != nil {
+		cck = db.Collection(model.CollectionCheckpoint)
 	}
+	// consecutive archived works
+	archived := 0
+	// whether the whole feed is processed
+	complete := false
 
 Loop:
 	for {
+		stop := false
+		if inc != nil && inc.Stop > 0 && db != nil {
+			ar, err := archivedIllusts(ctx, cp, cm, ri.Illusts, !dbOnly)
+			if err != nil {
+				logger.Error(err)
+				return
+			}
+			for _, il := range ri.Illusts {
+				if !il.Visible || !filter.matchIllust(il) {
+					continue
+				}
+				if ar[il.ID] {
+					archived++
+				} else {
+					archived = 0
+				}
+				if archived >= inc.Stop {
+					stop = true
+				}
+			}
+		}
+
+		ugoiras := fetchUgoiras(ctx, api, ri.Illusts, filter)
 		if db != nil {
-			err := savePixivIllusts(ctx, ri.Illusts, cu, cp, cpd, ct, cm, usersToUpdate)
+			err := savePixivIllusts(ctx, ri.Illusts, ugoiras, cu, cp, cpd, ct, cm, cc, usersToUpdate)
 			if err != nil {
 				logger.Error(err)
 				return
 			}
 			idb += len(ri.Illusts)
+
+			sids := make([]string, 0, len(ri.Illusts))
+			for _, il := range ri.Illusts {
+				sids = append(sids, strconv.Itoa(il.ID))
+				owners.users[il.User.ID] = struct{}{}
+			}
+			if err := bs.add(ctx, cb, sids); err != nil {
+				logger.Error(err)
+				return
+			}
 		}
 		if !dbOnly {
 			for _, il := range ri.Illusts {
@@ -225,58 +422,63 @@ Loop:
 					continue
 				}
 
-				if len(tags) != 0 {
-					if tagsMatchAll {
-						if !hasEveryTag(il.Tags, tags...) {
-							continue
-						}
-					} else {
-						if !hasAnyTag(il.Tags, tags...) {
-							continue
-						}
-					}
+				if !filter.matchIllust(il) {
+					continue
 				}
 
+				fields := illustFields(strconv.Itoa(il.User.ID), il.User.Name, il.User.Account, strconv.Itoa(il.ID), il.Title, il.Type)
 				if il.MetaSinglePage.OriginalImageURL != "" {
 					req, err := newPximgRequest(il.MetaSinglePage.OriginalImageURL)
 					if err != nil {
 						logger.Error(err)
 						continue
 					}
-					fp := pximgSingleFileWithDate(il.User.ID, req.URL)
+					fp, err := opts.Paths.illustPath(fields, 1, 0, il.MetaSinglePage.OriginalImageURL)
+					if err != nil {
+						logger.Error(err)
+						continue
+					}
 					t := &downloader.Task{
 						Request: req,
+						Group:   taskGroup(model.PostSourcePixivIllust, il.ID),
 						// string like `C:\test\12345\67891_p0_20200202123456.jpg`
-						LocalPath: filepath.Join(basePath, fp),
+						LocalPath: filepath.Join(opts.BasePath, fp),
 					}
 					if db != nil {
 						setAfterFinishedFunc(ctx, cm, t, il.MetaSinglePage.OriginalImageURL, fp)
 					}
 					dl.Add(t)
 				} else {
-					for _, iu := range il.MetaPages {
+					for page, iu := range il.MetaPages {
 						req, err := newPximgRequest(iu.ImageURLs.Original)
 						if err != nil {
 							logger.Error(err)
 							continue
 						}
 
-						fp := strconv.Itoa(il.User.ID) + "/" +
-							strconv.Itoa(il.ID) + "_" +
-							strings.ReplaceAll(PximgDate.FindString(req.URL.Path), "/", "") + "/" +
-							filepath.Base(req.URL.Path)
+						fp, err := opts.Paths.illustPath(fields, len(il.MetaPages), page, iu.ImageURLs.Original)
+						if err != nil {
+							logger.Error(err)
+							continue
+						}
 
 						t := &downloader.Task{
 							Request: req,
+							Group:   taskGroup(model.PostSourcePixivIllust, il.ID),
 							// string like `C:\test\12345\67890_2020134554\67890_p0.jpg`
 							LocalPath: filepath.Join(
-								basePath, fp)}
-						setAfterFinishedFunc(ctx, cm, t, iu.ImageURLs.Original, fp)
+								opts.BasePath, fp)}
+						if db != nil {
+							setAfterFinishedFunc(ctx, cm, t, iu.ImageURLs.Original, fp)
+						}
 
 						dl.Add(t)
 
 					}
 				}
+				if u, ok := ugoiras[il.ID]; ok {
+					addUgoiraTask(ctx, dl, opts, cm, fields, il.ID, u)
+				}
 				i++
 			}
 			logger.Info(i, "items were sent to download queue")
@@ -287,7 +489,24 @@ Loop:
 			}
 		}
 
-		if ri.NextURL == "" || limit != 0 && i >= limit {
+		if stop {
+			logger.Info(fmt.Sprintf("Stopped at %d consecutive works already archived", archived))
+		}
+		if cck != nil {
+			var err error
+			switch {
+			case stop || ri.NextURL == "":
+				// the feed is done
+				err = saveCheckpoint(ctx, cck, inc.Checkpoint, "")
+			case limit == 0 || i < limit:
+				err = saveCheckpoint(ctx, cck, inc.Checkpoint, ri.NextURL)
+			}
+			if err != nil {
+				logger.Error(err)
+			}
+		}
+		if stop || ri.NextURL == "" || limit != 0 && i >= limit {
+			complete = !stop && ri.NextURL == ""
 			break Loop
 		}
 
@@ -299,17 +518,34 @@ Loop:
 		}
 	}
 	logger.Info("All", i, "items processed")
+	if err := bs.finish(ctx, cb, complete); err != nil {
+		logger.Error(err)
+	}
 
 	updateUserSet(ctx, cu, cud, cm, api, usersToUpdate)
+	if db != nil && !dbOnly {
+		n, err := queueAssets(ctx, db, dl, opts.BasePath, userAssetTypes, owners)
+		if err != nil {
+			logger.Error(err)
+		} else if n > 0 {
+			logger.Info(n, "user profile images were sent to download queue")
+		}
+	}
 }
 
-// ProcessNovels saves pixiv novels to database
-func ProcessNovels(ctx context.Context, rn *pixiv.RespNovels, limit int, api *pixiv.AppAPI, db *mongo.Database, tags []string, tagsMatchAll, forceUpdateText bool) {
+// ProcessNovels saves pixiv novels to database like ProcessIllusts.
+// Only the novels selected by filter are saved with their text,
+// and their covers and embedded images are downloaded unless dbOnly is true.
+// If saveSeries is true, the whole series of the selected novels are saved as well.
+// If bs is not nil, the novels are recorded as bookmarks.
+func ProcessNovels(ctx context.Context, rn *pixiv.RespNovels, limit int, dl *downloader.Downloader, api *pixiv.AppAPI, opts *DownloadOptions, filter *Filter, bs *BookmarkSync, db *mongo.Database, dbOnly, forceUpdateText, saveSeries bool) {
 	logger := log.FromContext(ctx)
 	i := 0
 	usersToUpdate := make(map[int]struct{})
+	series := make(map[int]struct{})
+	owners := newAssetOwners()
 
-	var cu, cp, cpd, ct, cm, cc, cud *mongo.Collection
+	var cu, cp, cpd, ct, cm, cc, cud, cb *mongo.Collection
 	if db != nil {
 		cu = db.Collection(model.CollectionUser)
 		cp = db.Collection(model.CollectionPost)
@@ -318,17 +554,28 @@ func ProcessNovels(ctx context.Context, rn *pixiv.RespNovels, limit int, api *pi
 		cm = db.Collection(model.CollectionMedia)
 		cc = db.Collection(model.CollectionCollection)
 		cud = db.Collection(model.CollectionUserDetail)
+		cb = db.Collection(model.CollectionBookmark)
 	}
 
+	complete := false
 	for {
 		var err error
-		i, err = saveNovels(ctx, rn.Novels, cu, cp, cpd, ct, cm, cc, api, usersToUpdate, i, limit, forceUpdateText)
+		i, err = saveNovels(ctx, rn.Novels, cu, cp, cpd, ct, cm, cc, api, filter, usersToUpdate, series, owners, i, limit, forceUpdateText)
 		if err != nil {
 			logger.Error(err)
 			return
 		}
+		sids := make([]string, 0, len(rn.Novels))
+		for _, no := range rn.Novels {
+			sids = append(sids, strconv.Itoa(no.ID))
+		}
+		if err := bs.add(ctx, cb, sids); err != nil {
+			logger.Error(err)
+			return
+		}
 
 		if rn.NextURL == "" || limit != 0 && i >= limit {
+			complete = rn.NextURL == ""
 			break
 		}
 
@@ -340,6 +587,31 @@ func ProcessNovels(ctx context.Context, rn *pixiv.RespNovels, limit int, api *pi
 	}
 
 	logger.Info("All", i, "items processed")
+	if err := bs.finish(ctx, cb, complete); err != nil {
+		logger.Error(err)
+	}
+
+	if saveSeries {
+		ids := make([]int, 0, len(series))
+		for id := range series {
+			ids = append(ids, id)
+		}
+		sort.Ints(ids)
+		for _, id := range ids {
+			err := saveNovelSeries(ctx, api, id, cu, cp, cpd, ct, cm, cc, usersToUpdate, owners, forceUpdateText)
+			if err != nil {
+				logger.Error(err)
+			}
+		}
+	}
 
 	updateUserSet(ctx, cu, cud, cm, api, usersToUpdate)
+	if db != nil && !dbOnly {
+		n, err := queueAssets(ctx, db, dl, opts.BasePath, append(userAssetTypes, novelAssetTypes...), owners)
+		if err != nil {
+			logger.Error(err)
+		} else if n > 0 {
+			logger.Info(n, "novel and user profile images were sent to download queue")
+		}
+	}
 }
@@ -0,0 +1,230 @@
+package pixiv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/WOo0W/bowerbird/cli/log"
+	"github.com/WOo0W/bowerbird/downloader"
+	"github.com/WOo0W/bowerbird/model"
+	"github.com/WOo0W/go-pixiv/pixiv"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// the number of works saved at a time by RefreshPosts
+const refreshBatchSize = 30
+
+// stalePosts returns the IDs of visible posts from source
+// whose lastModified is before now - before.
+func stalePosts(ctx context.Context, cp *mongo.Collection, source model.PostSource, before time.Duration) ([]int, error) {
+	cur, err := cp.Find(ctx,
+		d{
+			{Key: "source", Value: source},
+			{Key: "sourceInvisible", Value: d{{Key: "$ne", Value: true}}},
+			{Key: "$or", Value: a{
+				d{{Key: "lastModified", Value: d{{Key: "$exists", Value: false}}}},
+				d{{Key: "lastModified", Value: d{{Key: "$lt", Value: time.Now().Add(-before)}}}},
+			}},
+		},
+		options.Find().SetProjection(d{{Key: "sourceID", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	ids := []int{}
+	for cur.Next(ctx) {
+		id, err := strconv.Atoi(cur.Current.Lookup("sourceID").StringValue())
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, cur.Err()
+}
+
+// isNotFound reports whether err is the response of a deleted work.
+func isNotFound(err error) bool {
+	var e *pixiv.ErrAppAPI
+	return errors.As(err, &e) && e.Response != nil && e.Response.StatusCode == http.StatusNotFound
+}
+
+// reuploadedIllusts returns the IDs of illusts in ils whose images
+// have URLs different from the images of the latest details in database.
+// The illusts saved without images are not included.
+func reuploadedIllusts(ctx context.Context, cp, cm *mongo.Collection, ils []*pixiv.Illust) (map[int]bool, error) {
+	sids := make([]string, 0, len(ils))
+	for _, il := range ils {
+		sids = append(sids, strconv.Itoa(il.ID))
+	}
+	cur, err := cp.Aggregate(ctx, pipelinePostsWithOwner(d{
+		{Key: "source", Value: model.PostSourcePixivIllust},
+		{Key: "sourceID", Value: d{{Key: "$in", Value: sids}}},
+	}))
+	if err != nil {
+		return nil, err
+	}
+	ps := []*model.Post{}
+	if err := cur.All(ctx, &ps); err != nil {
+		return nil, err
+	}
+	mids := []primitive.ObjectID{}
+	for _, p := range ps {
+		if p.PostDetail != nil {
+			mids = append(mids, p.PostDetail.MediaIDs...)
+		}
+	}
+
+	cur, err = cm.Find(ctx,
+		d{
+			{Key: "_id", Value: d{{Key: "$in", Value: mids}}},
+			{Key: "type", Value: model.MediaPixivIllust},
+		},
+		options.Find().SetProjection(d{{Key: "url", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	urls := make(map[primitive.ObjectID]string)
+	for cur.Next(ctx) {
+		urls[lookupObjectID(cur.Current)] = cur.Current.Lookup("url").StringValue()
+	}
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+
+	saved := make(map[string]map[string]struct{}, len(ps))
+	for _, p := range ps {
+		if p.PostDetail == nil {
+			continue
+		}
+		us := make(map[string]struct{})
+		for _, id := range p.PostDetail.MediaIDs {
+			if u, ok := urls[id]; ok {
+				us[u] = struct{}{}
+			}
+		}
+		saved[p.SourceID] = us
+	}
+
+	r := make(map[int]bool)
+	for _, il := range ils {
+		us := saved[strconv.Itoa(il.ID)]
+		if len(us) == 0 {
+			continue
+		}
+		fetched := illustURLs(il)
+		changed := len(fetched) != len(us)
+		for _, u := range fetched {
+			if _, ok := us[u]; !ok {
+				changed = true
+			}
+		}
+		if changed {
+			r[il.ID] = true
+		}
+	}
+	return r, nil
+}
+
+// RefreshPosts fetches the illusts and novels in database again, whose
+// lastModified is before now - before, and updates their stats, tags
+// and details. The works deleted on pixiv are marked as invisible.
+// The images of the illusts re-uploaded with new URLs and the new covers
+// and images of novels are downloaded unless dbOnly is true.
+func RefreshPosts(ctx context.Context, db *mongo.Database, api *pixiv.AppAPI, dl *downloader.Downloader, opts *DownloadOptions, before time.Duration, dbOnly bool) error {
+	logger := log.FromContext(ctx)
+	cp := db.Collection(model.CollectionPost)
+	cm := db.Collection(model.CollectionMedia)
+
+	ids, err := stalePosts(ctx, cp, model.PostSourcePixivIllust, before)
+	if err != nil {
+		return err
+	}
+	logger.Info("Refreshing", len(ids), "illusts...")
+	ils := make([]*pixiv.Illust, 0, refreshBatchSize)
+	flush := func() error {
+		if len(ils) == 0 {
+			return nil
+		}
+		reup, err := reuploadedIllusts(ctx, cp, cm, ils)
+		if err != nil {
+			return err
+		}
+		var saved, changed []*pixiv.Illust
+		for _, il := range ils {
+			if reup[il.ID] && il.Visible {
+				changed = append(changed, il)
+			} else {
+				saved = append(saved, il)
+			}
+		}
+		if len(saved) != 0 {
+			ProcessIllusts(ctx, &pixiv.RespIllusts{Illusts: saved}, 0, dl, api, opts, nil, nil, nil, db, true)
+		}
+		if len(changed) != 0 {
+			logger.Info(len(changed), "illusts have new images")
+			ProcessIllusts(ctx, &pixiv.RespIllusts{Illusts: changed}, 0, dl, api, opts, nil, nil, nil, db, dbOnly)
+		}
+		ils = ils[:0]
+		return nil
+	}
+	for i, id := range ids {
+		r, err := api.Illust.Detail(id)
+		if err != nil {
+			if !isNotFound(err) {
+				logger.Error(fmt.Sprintf("Fetching illust %d: %s", id, err))
+				continue
+			}
+			logger.Warn(fmt.Sprintf("[%d/%d] Illust %d is deleted", i+1, len(ids), id))
+			if err := updateInvisiblePost(ctx, model.PostSourcePixivIllust, strconv.Itoa(id), cp); err != nil {
+				return err
+			}
+			continue
+		}
+		ils = append(ils, &r.Illust)
+		if len(ils) >= refreshBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+			logger.Info(fmt.Sprintf("[%d/%d] Illusts refreshed", i+1, len(ids)))
+		}
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	ids, err = stalePosts(ctx, cp, model.PostSourcePixivNovel, before)
+	if err != nil {
+		return err
+	}
+	logger.Info("Refreshing", len(ids), "novels...")
+	nos := make([]*pixiv.Novel, 0, refreshBatchSize)
+	for i, id := range ids {
+		r, err := api.Novel.Detail(id)
+		if err != nil {
+			if !isNotFound(err) {
+				logger.Error(fmt.Sprintf("Fetching novel %d: %s", id, err))
+				continue
+			}
+			logger.Warn(fmt.Sprintf("[%d/%d] Novel %d is deleted", i+1, len(ids), id))
+			if err := updateInvisiblePost(ctx, model.PostSourcePixivNovel, strconv.Itoa(id), cp); err != nil {
+				return err
+			}
+			continue
+		}
+		nos = append(nos, &r.Novel)
+		if len(nos) >= refreshBatchSize {
+			ProcessNovels(ctx, &pixiv.RespNovels{Novels: nos}, 0, dl, api, opts, nil, nil, db, dbOnly, true, false)
+			nos = nos[:0]
+			logger.Info(fmt.Sprintf("[%d/%d] Novels refreshed", i+1, len(ids)))
+		}
+	}
+	if len(nos) != 0 {
+		ProcessNovels(ctx, &pixiv.RespNovels{Novels: nos}, 0, dl, api, opts, nil, nil, db, dbOnly, true, false)
+	}
+	return nil
+}
@@ -0,0 +1,237 @@
+package pixiv
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/WOo0W/bowerbird/cli/log"
+	"github.com/WOo0W/bowerbird/downloader"
+	"github.com/WOo0W/bowerbird/model"
+	"github.com/WOo0W/go-pixiv/pixiv"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// getAppAPI fetches u of pixiv App-API to v,
+// for the endpoints not covered by go-pixiv.
+func getAppAPI(api *pixiv.AppAPI, u string, v interface{}) error {
+	req, err := api.NewAuthorizedRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := api.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: HTTP %s", req.URL.Path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// novelSeries fetches the novel series from /v2/novel/series,
+// or the next page of it if nextURL is not empty.
+func novelSeries(api *pixiv.AppAPI, seriesID int, nextURL string) (*pixiv.RespNovelSeries, error) {
+	u := nextURL
+	if u == "" {
+		u = api.BaseURL + "/v2/novel/series?series_id=" + strconv.Itoa(seriesID)
+	}
+	r := &pixiv.RespNovelSeries{}
+	err := getAppAPI(api, u, r)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// respIllustSeries is the response from:
+//
+//  /v1/illust/series?illust_series_id=...
+type respIllustSeries struct {
+	IllustSeriesDetail struct {
+		ID              int    `json:"id"`
+		Title           string `json:"title"`
+		Caption         string `json:"caption"`
+		SeriesWorkCount int    `json:"series_work_count"`
+	} `json:"illust_series_detail"`
+	Illusts []*pixiv.Illust `json:"illusts"`
+	NextURL string          `json:"next_url"`
+}
+
+// illustSeries fetches the illust series from /v1/illust/series,
+// or the next page of it if nextURL is not empty.
+func illustSeries(api *pixiv.AppAPI, seriesID int, nextURL string) (*respIllustSeries, error) {
+	u := nextURL
+	if u == "" {
+		u = api.BaseURL + "/v1/illust/series?illust_series_id=" + strconv.Itoa(seriesID)
+	}
+	r := &respIllustSeries{}
+	err := getAppAPI(api, u, r)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// postIDs returns the IDs of posts with the sourceIDs in order.
+// The posts not in database are skipped.
+func postIDs(ctx context.Context, cp *mongo.Collection, source model.PostSource, sourceIDs []string) ([]primitive.ObjectID, error) {
+	cur, err := cp.Find(ctx,
+		d{
+			{Key: "source", Value: source},
+			{Key: "sourceID", Value: d{{Key: "$in", Value: sourceIDs}}},
+		},
+		options.Find().SetProjection(d{{Key: "sourceID", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]primitive.ObjectID, len(sourceIDs))
+	for cur.Next(ctx) {
+		m[cur.Current.Lookup("sourceID").StringValue()] = lookupObjectID(cur.Current)
+	}
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+	ids := make([]primitive.ObjectID, 0, len(sourceIDs))
+	for _, sid := range sourceIDs {
+		if id, ok := m[sid]; ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// saveNovelSeries saves every novel in the series with saveNovels,
+// and the Collection of the series with the ordered PostIDs.
+// The novels not in the Collection last time are reported as new chapters.
+func saveNovelSeries(ctx context.Context, api *pixiv.AppAPI, seriesID int, cu, cp, cpd, ct, cm, cc *mongo.Collection, usersToUpdate map[int]struct{}, owners *assetOwners, forceUpdateText bool) error {
+	logger := log.FromContext(ctx)
+	filter := d{
+		{Key: "source", Value: model.CollectionSourcePixivNovelSeries},
+		{Key: "sourceID", Value: strconv.Itoa(seriesID)},
+	}
+	old := &model.Collection{}
+	err := cc.FindOne(ctx, filter).Decode(old)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return err
+	}
+
+	var detail *pixiv.NovelSeriesDetail
+	sids := []string{}
+	nextURL := ""
+	for {
+		r, err := novelSeries(api, seriesID, nextURL)
+		if err != nil {
+			return err
+		}
+		if detail == nil {
+			detail = &r.NovelSeriesDetail
+			logger.Info(fmt.Sprintf("Saving novel series: %s (%d)", detail.Title, seriesID))
+		}
+		_, err = saveNovels(ctx, r.Novels, cu, cp, cpd, ct, cm, cc, api, nil, usersToUpdate, nil, owners, 0, 0, forceUpdateText)
+		if err != nil {
+			return err
+		}
+		for _, no := range r.Novels {
+			sids = append(sids, strconv.Itoa(no.ID))
+		}
+		if r.NextURL == "" {
+			break
+		}
+		nextURL = r.NextURL
+	}
+
+	ids, err := postIDs(ctx, cp, model.PostSourcePixivNovel, sids)
+	if err != nil {
+		return err
+	}
+	_, err = cc.UpdateOne(ctx, filter,
+		d{{Key: "$set", Value: d{
+			{Key: "name", Value: detail.Title},
+			{Key: "postIDs", Value: ids},
+			{Key: "extension.pixiv", Value: &model.PixivSeries{
+				Caption:      detail.Caption,
+				IsConcluded:  detail.IsConcluded,
+				ContentCount: detail.ContentCount,
+			}},
+		}}},
+		optsUUpsert)
+	if err != nil {
+		return err
+	}
+
+	saved := make(map[primitive.ObjectID]struct{}, len(old.PostIDs))
+	for _, id := range old.PostIDs {
+		saved[id] = struct{}{}
+	}
+	n := 0
+	for _, id := range ids {
+		if _, ok := saved[id]; !ok {
+			n++
+		}
+	}
+	if len(old.PostIDs) != 0 && n > 0 {
+		logger.Info(fmt.Sprintf("Found %d new chapters in novel series: %s (%d)", n, detail.Title, seriesID))
+	}
+	return nil
+}
+
+// ProcessIllustSeries processes every illust in the series with ProcessIllusts
+// in the order of creation, and saves the Collection of the series
+// with the ordered PostIDs if db is not nil.
+func ProcessIllustSeries(ctx context.Context, seriesID, limit int, dl *downloader.Downloader, api *pixiv.AppAPI, opts *DownloadOptions, filter *Filter, db *mongo.Database, dbOnly bool) error {
+	logger := log.FromContext(ctx)
+	r, err := illustSeries(api, seriesID, "")
+	if err != nil {
+		return err
+	}
+	detail := r.IllustSeriesDetail
+	logger.Info(fmt.Sprintf("Saving illust series: %s (%d)", detail.Title, seriesID))
+	ils := r.Illusts
+	for r.NextURL != "" {
+		r, err = illustSeries(api, seriesID, r.NextURL)
+		if err != nil {
+			return err
+		}
+		ils = append(ils, r.Illusts...)
+	}
+	sort.SliceStable(ils, func(i, j int) bool {
+		return ils[i].CreateDate.Before(ils[j].CreateDate)
+	})
+
+	// the illusts are all fetched, so NextURL is left empty
+	ProcessIllusts(ctx, &pixiv.RespIllusts{Illusts: ils}, limit, dl, api, opts, filter, nil, nil, db, dbOnly)
+	if db == nil {
+		return nil
+	}
+
+	sids := make([]string, 0, len(ils))
+	for _, il := range ils {
+		sids = append(sids, strconv.Itoa(il.ID))
+	}
+	ids, err := postIDs(ctx, db.Collection(model.CollectionPost), model.PostSourcePixivIllust, sids)
+	if err != nil {
+		return err
+	}
+	_, err = db.Collection(model.CollectionCollection).UpdateOne(ctx,
+		d{
+			{Key: "source", Value: model.CollectionSourcePixivIllustSeries},
+			{Key: "sourceID", Value: strconv.Itoa(seriesID)},
+		},
+		d{{Key: "$set", Value: d{
+			{Key: "name", Value: detail.Title},
+			{Key: "postIDs", Value: ids},
+			{Key: "extension.pixiv", Value: &model.PixivSeries{
+				Caption:      detail.Caption,
+				ContentCount: detail.SeriesWorkCount,
+			}},
+		}}},
+		optsUUpsert)
+	return err
+}
@@ -0,0 +1,459 @@
+package pixiv
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/WOo0W/bowerbird/cli/log"
+	"github.com/WOo0W/bowerbird/downloader"
+	"github.com/WOo0W/bowerbird/helper"
+	"github.com/WOo0W/bowerbird/model"
+	"github.com/WOo0W/bowerbird/storage"
+	"github.com/dustin/go-humanize"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// localMediaFiles returns the media with path in database.
+func localMediaFiles(ctx context.Context, cm *mongo.Collection) ([]model.Media, error) {
+	cur, err := cm.Find(ctx,
+		d{{Key: "path", Value: d{{Key: "$exists", Value: true}}}},
+		options.Find().SetSort(d{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	ms := []model.Media{}
+	err = cur.All(ctx, &ms)
+	return ms, err
+}
+
+// DedupeMedia finds the media files under basePath with the same SHA-256
+// and replaces the duplicated files with hard links to the first one.
+// The files are hashed again before linking, and skipped if the contents
+// differ because of stale hashes in database.
+// If dryRun is true, it only reports the duplicated files.
+// The missing hashes of the media are computed and saved to database.
+func DedupeMedia(ctx context.Context, db *mongo.Database, basePath string, dryRun bool) error {
+	logger := log.FromContext(ctx)
+	cm := db.Collection(model.CollectionMedia)
+	ms, err := localMediaFiles(ctx, cm)
+	if err != nil {
+		return err
+	}
+
+	groups := make(map[string][]string)
+	hashes := []string{}
+	for _, m := range ms {
+		fp := filepath.Join(basePath, m.Path)
+		if m.SHA256 == "" {
+			h, size, err := helper.FileSHA256(fp)
+			if err != nil {
+				if !os.IsNotExist(err) {
+					logger.Warn("Hashing file:", err)
+				}
+				continue
+			}
+			_, err = cm.UpdateOne(ctx, d{{Key: "_id", Value: m.ID}},
+				d{{Key: "$set", Value: d{
+					{Key: "sha256", Value: h},
+					{Key: "size", Value: size},
+				}}})
+			if err != nil {
+				return err
+			}
+			m.SHA256 = h
+		}
+		if _, ok := groups[m.SHA256]; !ok {
+			hashes = append(hashes, m.SHA256)
+		}
+		groups[m.SHA256] = append(groups[m.SHA256], fp)
+	}
+
+	var files int
+	var saved int64
+	for _, h := range hashes {
+		fps := groups[h]
+		if len(fps) < 2 {
+			continue
+		}
+		src := fps[0]
+		fi, err := os.Stat(src)
+		if err != nil {
+			logger.Warn(err)
+			continue
+		}
+		for _, dst := range fps[1:] {
+			di, err := os.Stat(dst)
+			if err != nil || os.SameFile(fi, di) {
+				continue
+			}
+			same, err := sameContent(src, dst)
+			if err != nil {
+				logger.Warn("Hashing file:", err)
+				continue
+			}
+			if !same {
+				logger.Warn(fmt.Sprintf("Skipped %q: the content differs from %q with the same hash in database", dst, src))
+				continue
+			}
+			files++
+			saved += di.Size()
+			logger.Info(fmt.Sprintf("Duplicated file: %q -> %q", dst, src))
+			if dryRun {
+				continue
+			}
+			if err := replaceWithLink(src, dst); err != nil {
+				logger.Error(err)
+				files--
+				saved -= di.Size()
+			}
+		}
+	}
+
+	if dryRun {
+		logger.Info(fmt.Sprintf("%d duplicated files found, %s can be saved", files, humanize.Bytes(uint64(saved))))
+	} else {
+		logger.Info(fmt.Sprintf("%d duplicated files replaced with hard links, %s saved", files, humanize.Bytes(uint64(saved))))
+	}
+	return nil
+}
+
+// sameContent reports whether the files a and b on disk have the same SHA-256.
+func sameContent(a, b string) (bool, error) {
+	ha, _, err := helper.FileSHA256(a)
+	if err != nil {
+		return false, err
+	}
+	hb, _, err := helper.FileSHA256(b)
+	if err != nil {
+		return false, err
+	}
+	return ha == hb, nil
+}
+
+// replaceWithLink replaces dst with a hard link to src.
+func replaceWithLink(src, dst string) error {
+	tmp := dst + ".link"
+	if err := os.Link(src, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// checkMediaFile returns the problem of the media file, or "" if it is fine.
+func checkMediaFile(ctx context.Context, s storage.Storage, m *model.Media) (string, error) {
+	fi, err := s.Stat(ctx, m.Path)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotExist) {
+			return "missing", nil
+		}
+		return "", err
+	}
+	if m.Size != 0 && fi.Size != int64(m.Size) {
+		return fmt.Sprintf("size %d doesn't match %d", fi.Size, m.Size), nil
+	}
+
+	f, err := s.Open(ctx, m.Path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	// hash and decode the file in one read
+	h := sha256.New()
+	err = downloader.CheckImage(io.TeeReader(f, h))
+	if err != nil && err != image.ErrFormat {
+		return "broken image: " + err.Error(), nil
+	}
+	if m.SHA256 != "" {
+		if _, err := io.Copy(h, f); err != nil {
+			return "", err
+		}
+		if x := hex.EncodeToString(h.Sum(nil)); x != m.SHA256 {
+			return fmt.Sprintf("hash %s doesn't match %s", x, m.SHA256), nil
+		}
+	}
+	return "", nil
+}
+
+// isTempFile reports whether the file is left by unfinished downloads.
+func isTempFile(name string) bool {
+	return strings.HasSuffix(name, ".part") ||
+		strings.HasSuffix(name, ".part.json") ||
+		strings.HasSuffix(name, ".link") ||
+		strings.HasSuffix(name, ".tmp")
+}
+
+// mediaNames returns the names in storage of the media files,
+// including the animations converted from ugoira.
+func mediaNames(ms []model.Media) map[string]struct{} {
+	names := make(map[string]struct{}, len(ms))
+	for i := range ms {
+		m := &ms[i]
+		names[storage.CleanName(m.Path)] = struct{}{}
+		if m.Extension != nil && m.Extension.Pixiv != nil && m.Extension.Pixiv.ConvertedPath != "" {
+			names[storage.CleanName(m.Extension.Pixiv.ConvertedPath)] = struct{}{}
+		}
+	}
+	return names
+}
+
+// CheckMedia checks the files of media with path in s.
+// It reports missing, truncated and broken files, hash mismatches
+// and the orphan files not referenced by any media.
+// If dl is not nil, the media with problems are added to dl to download again
+// under basePath, and the number of added tasks is returned.
+func CheckMedia(ctx context.Context, db *mongo.Database, s storage.Storage, basePath string, dl *downloader.Downloader) (int, error) {
+	logger := log.FromContext(ctx)
+	cm := db.Collection(model.CollectionMedia)
+	ms, err := localMediaFiles(ctx, cm)
+	if err != nil {
+		return 0, err
+	}
+
+	names := mediaNames(ms)
+	problems, queued := 0, 0
+	for i := range ms {
+		m := &ms[i]
+		p, err := checkMediaFile(ctx, s, m)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Checking %q: %s", m.Path, err))
+			continue
+		}
+		if p == "" {
+			continue
+		}
+		problems++
+		logger.Warn(fmt.Sprintf("Media %s %q: %s", m.ID.Hex(), m.Path, p))
+
+		if dl != nil && m.URL != "" {
+			req, err := newPximgRequest(m.URL)
+			if err != nil {
+				logger.Error(err)
+				continue
+			}
+			t := &downloader.Task{
+				Request:   req,
+				LocalPath: filepath.Join(basePath, filepath.FromSlash(m.Path)),
+				Overwrite: true,
+			}
+			setAfterFinishedFunc(ctx, cm, t, m.URL, m.Path)
+			dl.Add(t)
+			queued++
+		}
+	}
+
+	orphans := 0
+	err = s.Walk(ctx, func(fi *storage.FileInfo) error {
+		if isTempFile(fi.Name) {
+			return nil
+		}
+		if _, ok := names[fi.Name]; !ok {
+			orphans++
+			logger.Warn(fmt.Sprintf("Orphan file: %q", fi.Name))
+		}
+		return nil
+	})
+	if err != nil {
+		return queued, err
+	}
+
+	logger.Info(fmt.Sprintf("%d media checked: %d with problems, %d orphan files", len(ms), problems, orphans))
+	return queued, nil
+}
+
+// pipelineIllustsWithOwner finds pixiv illusts with the latest PostDetail,
+// and the owner with the latest UserDetail.
+var pipelineIllustsWithOwner = a{
+	d{{Key: "$match", Value: d{{Key: "source", Value: model.PostSourcePixivIllust}}}},
+	d{{Key: "$lookup", Value: d{
+		{Key: "from", Value: model.CollectionPostDetail},
+		{Key: "localField", Value: "_id"},
+		{Key: "foreignField", Value: "postID"},
+		{Key: "as", Value: "postDetail"},
+	}}},
+	d{{Key: "$set", Value: d{{Key: "postDetail", Value: d{{Key: "$arrayElemAt", Value: a{"$postDetail", -1}}}}}}},
+	d{{Key: "$lookup", Value: d{
+		{Key: "from", Value: model.CollectionUser},
+		{Key: "localField", Value: "ownerID"},
+		{Key: "foreignField", Value: "_id"},
+		{Key: "as", Value: "owner"},
+	}}},
+	d{{Key: "$set", Value: d{{Key: "owner", Value: d{{Key: "$arrayElemAt", Value: a{"$owner", 0}}}}}}},
+	d{{Key: "$lookup", Value: d{
+		{Key: "from", Value: model.CollectionUserDetail},
+		{Key: "localField", Value: "owner._id"},
+		{Key: "foreignField", Value: "userID"},
+		{Key: "as", Value: "owner.userDetail"},
+	}}},
+	d{{Key: "$set", Value: d{{Key: "owner.userDetail", Value: d{{Key: "$arrayElemAt", Value: a{"$owner.userDetail", -1}}}}}}},
+}
+
+// postMediaIDs returns the IDs of the media of pd,
+// including the zip of ugoira.
+func postMediaIDs(pd *model.PostDetail) []primitive.ObjectID {
+	ids := pd.MediaIDs
+	if ext := pd.Extension; ext != nil && ext.PixivIllust != nil && !ext.PixivIllust.UgoiraMediaID.IsZero() {
+		ids = append(ids[:len(ids):len(ids)], ext.PixivIllust.UgoiraMediaID)
+	}
+	return ids
+}
+
+// pipelinePostsWithOwner finds posts matching match
+// like pipelineIllustsWithOwner.
+func pipelinePostsWithOwner(match d) a {
+	return append(a{d{{Key: "$match", Value: match}}}, pipelineIllustsWithOwner[1:]...)
+}
+
+// postIllustFields returns the placeholders of path templates of the post
+// found with pipelineIllustsWithOwner.
+func postIllustFields(p *model.Post) map[string]string {
+	var title, typ, name, account string
+	if p.PostDetail.Extension != nil && p.PostDetail.Extension.PixivIllust != nil {
+		title = p.PostDetail.Extension.PixivIllust.Title
+		typ = p.PostDetail.Extension.PixivIllust.Type
+	}
+	if ud := p.Owner.UserDetail; ud != nil {
+		name = ud.Name
+		if ud.Extension != nil && ud.Extension.Pixiv != nil {
+			account = ud.Extension.Pixiv.Account
+		}
+	}
+	return illustFields(p.Owner.SourceID, name, account, p.SourceID, title, typ)
+}
+
+// ReorganizeMedia moves the files of pixiv illusts in s to the paths
+// built with the templates, and updates the path of media.
+// The zips of ugoira are moved with the animations converted from them.
+// The files are not moved if dryRun is true.
+func ReorganizeMedia(ctx context.Context, db *mongo.Database, s storage.Storage, paths *PathTemplates, dryRun bool) error {
+	logger := log.FromContext(ctx)
+	cm := db.Collection(model.CollectionMedia)
+	cur, err := db.Collection(model.CollectionPost).Aggregate(ctx, pipelineIllustsWithOwner)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+
+	moved, failed := 0, 0
+	// move renames the file from to and sets the field of the media with the ID.
+	move := func(id primitive.ObjectID, field, from, to string) error {
+		if to == from {
+			return nil
+		}
+		logger.Info(fmt.Sprintf("Moving %q to %q", from, to))
+		if dryRun {
+			moved++
+			return nil
+		}
+		if err := s.Rename(ctx, from, to); err != nil {
+			logger.Error(err)
+			failed++
+			return nil
+		}
+		_, err := cm.UpdateOne(ctx,
+			d{{Key: "_id", Value: id}},
+			d{{Key: "$set", Value: d{{Key: field, Value: to}}}})
+		if err != nil {
+			return err
+		}
+		moved++
+		return nil
+	}
+	for cur.Next(ctx) {
+		p := &model.Post{}
+		if err := cur.Decode(p); err != nil {
+			return err
+		}
+		if p.PostDetail == nil || p.Owner == nil || len(p.PostDetail.MediaIDs) == 0 {
+			continue
+		}
+		fields := postIllustFields(p)
+
+		mcur, err := cm.Find(ctx, d{{Key: "_id", Value: d{{Key: "$in", Value: postMediaIDs(p.PostDetail)}}}})
+		if err != nil {
+			return err
+		}
+		ms := []model.Media{}
+		if err := mcur.All(ctx, &ms); err != nil {
+			return err
+		}
+		byID := make(map[primitive.ObjectID]*model.Media, len(ms))
+		for i := range ms {
+			byID[ms[i].ID] = &ms[i]
+		}
+
+		pages := make([]*model.Media, 0, len(p.PostDetail.MediaIDs))
+		var zip *model.Media
+		for _, id := range postMediaIDs(p.PostDetail) {
+			m, ok := byID[id]
+			if !ok {
+				continue
+			}
+			switch m.Type {
+			case model.MediaPixivIllust:
+				pages = append(pages, m)
+			case model.MediaPixivUgoira:
+				zip = m
+			}
+		}
+
+		for page, m := range pages {
+			if m.Path == "" {
+				continue
+			}
+			np, err := paths.illustPath(fields, len(pages), page, m.URL)
+			if err != nil {
+				logger.Error(err)
+				failed++
+				continue
+			}
+			if err := move(m.ID, "path", m.Path, np); err != nil {
+				return err
+			}
+		}
+
+		if zip == nil || zip.Path == "" {
+			continue
+		}
+		np, err := paths.illustPath(fields, 1, 0, zip.URL)
+		if err != nil {
+			logger.Error(err)
+			failed++
+			continue
+		}
+		if err := move(zip.ID, "path", zip.Path, np); err != nil {
+			return err
+		}
+		if ext := zip.Extension; ext != nil && ext.Pixiv != nil && ext.Pixiv.ConvertedPath != "" {
+			cp := ext.Pixiv.ConvertedPath
+			if err := move(zip.ID, "extension.pixiv.convertedPath", cp, strings.TrimSuffix(np, path.Ext(np))+path.Ext(cp)); err != nil {
+				return err
+			}
+		}
+	}
+	if err := cur.Err(); err != nil {
+		return err
+	}
+
+	if dryRun {
+		logger.Info(moved, "files to be moved")
+	} else {
+		logger.Info(fmt.Sprintf("%d files moved, %d failed", moved, failed))
+	}
+	return nil
+}
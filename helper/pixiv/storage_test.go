@@ -0,0 +1,52 @@
+package pixiv
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/WOo0W/bowerbird/model"
+)
+
+func TestSameContent(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, s string) string {
+		fp := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(fp, []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return fp
+	}
+	a, b := write("a.jpg", "image"), write("b.jpg", "image")
+	// c has the same hash as a in database, but is replaced on disk
+	c := write("c.jpg", "replaced")
+
+	if same, err := sameContent(a, b); err != nil || !same {
+		t.Errorf("same files reported different: %v", err)
+	}
+	if same, err := sameContent(a, c); err != nil || same {
+		t.Errorf("file with stale hash reported same: %v", err)
+	}
+	if _, err := sameContent(a, filepath.Join(dir, "missing.jpg")); err == nil {
+		t.Error("no error for missing file")
+	}
+}
+
+func TestMediaNames(t *testing.T) {
+	ms := []model.Media{
+		{Path: "/1/1_p0.jpg"},
+		{
+			Path:      "1/2_ugoira.zip",
+			Extension: &model.ExtMedia{Pixiv: &model.PixivMedia{ConvertedPath: "1/2_ugoira.webm"}},
+		},
+	}
+	names := mediaNames(ms)
+	for _, n := range []string{"1/1_p0.jpg", "1/2_ugoira.zip", "1/2_ugoira.webm"} {
+		if _, ok := names[n]; !ok {
+			t.Errorf("%q reported as orphan", n)
+		}
+	}
+	if len(names) != 3 {
+		t.Errorf("expected 3 names, got %d", len(names))
+	}
+}
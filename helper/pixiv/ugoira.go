@@ -0,0 +1,207 @@
+package pixiv
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/WOo0W/bowerbird/cli/log"
+	"github.com/WOo0W/bowerbird/downloader"
+	"github.com/WOo0W/bowerbird/helper/ugoira"
+	"github.com/WOo0W/bowerbird/model"
+	"github.com/WOo0W/bowerbird/storage"
+	"github.com/WOo0W/go-pixiv/pixiv"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ugoiraZipSize matches the size in the URL of ugoira zip
+// like `12345_ugoira600x600.zip`.
+var ugoiraZipSize = regexp.MustCompile(`_ugoira\d+x\d+\.zip$`)
+
+// ugoiraInfo is the metadata of a ugoira.
+type ugoiraInfo struct {
+	// ZipURL is the URL of the zip with original frames.
+	ZipURL string
+	Frames []ugoira.Frame
+}
+
+// fetchUgoiras fetches the metadata of the visible ugoira in ils
+// selected by filter. The map is keyed by illust ID.
+func fetchUgoiras(ctx context.Context, api *pixiv.AppAPI, ils []*pixiv.Illust, filter *Filter) map[int]*ugoiraInfo {
+	logger := log.FromContext(ctx)
+	r := make(map[int]*ugoiraInfo)
+	for _, il := range ils {
+		if !il.Visible || il.Type != string(pixiv.TUgoira) || !filter.matchIllust(il) {
+			continue
+		}
+		rm, err := api.Illust.UgoiraMetadata(il.ID)
+		if err != nil {
+			logger.Error("Fetching ugoira metadata of", il.ID, err)
+			continue
+		}
+		m := &rm.UgoiraMetadata
+		if m.ZipURLs.Medium == "" {
+			continue
+		}
+		u := &ugoiraInfo{
+			// the metadata only gives the URL of 600x600 frames
+			ZipURL: ugoiraZipSize.ReplaceAllString(m.ZipURLs.Medium, "_ugoira1920x1080.zip"),
+		}
+		for _, fr := range m.Frames {
+			u.Frames = append(u.Frames, ugoira.Frame{File: fr.File, Delay: fr.Delay})
+		}
+		r[il.ID] = u
+	}
+	return r
+}
+
+func insertUgoiraMedia(ctx context.Context, cm *mongo.Collection, u *ugoiraInfo) (primitive.ObjectID, error) {
+	delays := make([]int, 0, len(u.Frames))
+	files := make([]string, 0, len(u.Frames))
+	for _, fr := range u.Frames {
+		delays = append(delays, fr.Delay)
+		files = append(files, fr.File)
+	}
+	r, err := cm.FindOneAndUpdate(ctx,
+		d{{Key: "url", Value: u.ZipURL}},
+		d{{Key: "$set", Value: d{
+			{Key: "type", Value: model.MediaPixivUgoira},
+			{Key: "extension.pixiv.ugoiraDelay", Value: delays},
+			{Key: "extension.pixiv.ugoiraFrames", Value: files},
+		}}},
+		optsFUIDOnly).DecodeBytes()
+	if err != nil {
+		return primitive.ObjectID{}, err
+	}
+	return lookupObjectID(r), nil
+}
+
+// convertedName returns the name of the animation converted from the zip.
+func convertedName(name string, conv *ugoira.Converter) string {
+	return strings.TrimSuffix(name, path.Ext(name)) + conv.Ext()
+}
+
+// convertUgoira converts the zip named name in s
+// and puts the animation next to it.
+func convertUgoira(ctx context.Context, s storage.Storage, conv *ugoira.Converter, cm *mongo.Collection, u *ugoiraInfo, name string) error {
+	out := convertedName(name, conv)
+	if _, err := s.Stat(ctx, out); err != nil {
+		if !errors.Is(err, storage.ErrNotExist) {
+			return err
+		}
+
+		rc, err := s.Open(ctx, name)
+		if err != nil {
+			return err
+		}
+		b, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+		zr, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+		if err != nil {
+			return err
+		}
+
+		dir, err := ioutil.TempDir("", "bowerbird-ugoira-")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+		tmp := filepath.Join(dir, "out"+conv.Ext())
+		if err := conv.Convert(ctx, zr, u.Frames, tmp); err != nil {
+			return err
+		}
+		f, err := os.Open(tmp)
+		if err != nil {
+			return err
+		}
+		err = s.Put(ctx, out, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	if cm == nil {
+		return nil
+	}
+	_, err := cm.UpdateOne(ctx,
+		d{{Key: "url", Value: u.ZipURL}},
+		d{{Key: "$set", Value: d{{Key: "extension.pixiv.convertedPath", Value: out}}}})
+	return err
+}
+
+// ugoiraQueue converts ugoira in the background,
+// with at most one conversion per CPU at the same time.
+type ugoiraQueue struct {
+	once sync.Once
+	sem  chan struct{}
+	wg   sync.WaitGroup
+}
+
+func (q *ugoiraQueue) add(fn func()) {
+	q.once.Do(func() {
+		q.sem = make(chan struct{}, runtime.NumCPU())
+	})
+	q.wg.Add(1)
+	go func() {
+		defer q.wg.Done()
+		q.sem <- struct{}{}
+		defer func() { <-q.sem }()
+		fn()
+	}()
+}
+
+// addUgoiraTask adds the task downloading the zip of ugoira,
+// which is converted in opts.ugoiras after finished if opts.Ugoira is not nil.
+func addUgoiraTask(ctx context.Context, dl *downloader.Downloader, opts *DownloadOptions, cm *mongo.Collection, fields map[string]string, id int, u *ugoiraInfo) {
+	logger := log.FromContext(ctx)
+	req, err := newPximgRequest(u.ZipURL)
+	if err != nil {
+		logger.Error(err)
+		return
+	}
+	fp, err := opts.Paths.illustPath(fields, 1, 0, u.ZipURL)
+	if err != nil {
+		logger.Error(err)
+		return
+	}
+	t := &downloader.Task{
+		Request:   req,
+		Group:     taskGroup(model.PostSourcePixivIllust, id),
+		LocalPath: filepath.Join(opts.BasePath, fp),
+	}
+	if cm != nil {
+		setAfterFinishedFunc(ctx, cm, t, u.ZipURL, fp)
+	}
+	if opts.Ugoira != nil {
+		var s storage.Storage = storage.NewLocal(opts.BasePath)
+		if dl.Storage != nil {
+			s = dl.Storage
+		}
+		saveMedia := t.AfterFinished
+		t.AfterFinished = func(t *downloader.Task) {
+			if saveMedia != nil {
+				saveMedia(t)
+			}
+			opts.ugoiras.add(func() {
+				if err := convertUgoira(ctx, s, opts.Ugoira, cm, u, fp); err != nil {
+					logger.Error("Converting ugoira", id, err)
+				}
+			})
+		}
+	}
+	dl.Add(t)
+}
@@ -0,0 +1,36 @@
+package pixiv
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestUgoiraQueue(t *testing.T) {
+	opts := &DownloadOptions{}
+	var mu sync.Mutex
+	running, max, done := 0, 0, 0
+	for i := 0; i < 4*runtime.NumCPU(); i++ {
+		opts.ugoiras.add(func() {
+			mu.Lock()
+			running++
+			if running > max {
+				max = running
+			}
+			mu.Unlock()
+			time.Sleep(5 * time.Millisecond)
+			mu.Lock()
+			running--
+			done++
+			mu.Unlock()
+		})
+	}
+	opts.WaitUgoira()
+	if done != 4*runtime.NumCPU() {
+		t.Errorf("%d conversions done before waiting returns, want %d", done, 4*runtime.NumCPU())
+	}
+	if max > runtime.NumCPU() {
+		t.Errorf("%d conversions at the same time, want at most %d", max, runtime.NumCPU())
+	}
+}
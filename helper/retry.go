@@ -1,14 +1,54 @@
 package helper
 
 import (
+	"math/rand"
 	"net/http"
+	"strconv"
 	"time"
 )
 
 // DefaultBackoff returns min*2**tries or max as the retry time
+// minus a random jitter of up to a quarter of it.
+// If resp has a Retry-After header, its value clamped to max is used instead.
 func DefaultBackoff(min, max time.Duration, attemptNum int, resp *http.Response) time.Duration {
-	if sleep := (1 << attemptNum) * min; sleep < max && sleep != 0 {
-		return sleep
+	if d, ok := RetryAfter(resp); ok {
+		if d > max {
+			return max
+		}
+		return d
 	}
-	return max
+	sleep := max
+	if s := (1 << attemptNum) * min; s < max && s > 0 {
+		sleep = s
+	}
+	if j := int64(sleep / 4); j > 0 {
+		sleep -= time.Duration(rand.Int63n(j))
+	}
+	return sleep
+}
+
+// RetryAfter parses the Retry-After header of resp,
+// which is in seconds or in HTTP date.
+func RetryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp == nil {
+		return 0, false
+	}
+	s := resp.Header.Get("Retry-After")
+	if s == "" {
+		return 0, false
+	}
+	if sec, err := strconv.Atoi(s); err == nil {
+		if sec < 0 {
+			return 0, false
+		}
+		return time.Duration(sec) * time.Second, true
+	}
+	if t, err := http.ParseTime(s); err == nil {
+		d := time.Until(t)
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+	return 0, false
 }
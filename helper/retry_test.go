@@ -0,0 +1,31 @@
+package helper
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestDefaultBackoff(t *testing.T) {
+	min, max := time.Second, 30*time.Second
+	for _, c := range []struct {
+		retryAfter string
+		want       time.Duration
+	}{
+		{"10", 10 * time.Second},
+		{"0", 0},
+		{"3600", max},
+		{time.Now().Add(24 * time.Hour).UTC().Format(http.TimeFormat), max},
+	} {
+		resp := &http.Response{Header: http.Header{"Retry-After": {c.retryAfter}}}
+		if d := DefaultBackoff(min, max, 0, resp); d != c.want {
+			t.Errorf("Retry-After %q: got %s, want %s", c.retryAfter, d, c.want)
+		}
+	}
+
+	for i := 0; i < 10; i++ {
+		if d := DefaultBackoff(min, max, i, nil); d > max || d <= 0 {
+			t.Errorf("attempt %d: got %s out of (0, %s]", i, d, max)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package ugoira
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"io"
+)
+
+// encodeGIF encodes the frames loaded by load to GIF.
+// The colors are reduced to the Plan 9 palette with dithering.
+func encodeGIF(w io.Writer, frames []Frame, load func(i int) (image.Image, error)) error {
+	g := &gif.GIF{
+		Image: make([]*image.Paletted, 0, len(frames)),
+		Delay: make([]int, 0, len(frames)),
+	}
+	for i, fr := range frames {
+		img, err := load(i)
+		if err != nil {
+			return err
+		}
+		p := image.NewPaletted(img.Bounds(), palette.Plan9)
+		draw.FloydSteinberg.Draw(p, p.Rect, img, img.Bounds().Min)
+		g.Image = append(g.Image, p)
+		// GIF delays are in 1/100 seconds
+		g.Delay = append(g.Delay, (fr.Delay+5)/10)
+	}
+	return gif.EncodeAll(w, g)
+}
+
+// apngWriter writes the chunks of PNG.
+type apngWriter struct {
+	w   *bufio.Writer
+	seq uint32
+	err error
+}
+
+func (a *apngWriter) chunk(typ string, data []byte) {
+	if a.err != nil {
+		return
+	}
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(len(data)))
+	a.w.Write(b[:])
+	a.w.WriteString(typ)
+	a.w.Write(data)
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(typ))
+	crc.Write(data)
+	binary.BigEndian.PutUint32(b[:], crc.Sum32())
+	_, a.err = a.w.Write(b[:])
+}
+
+func (a *apngWriter) nextSeq() uint32 {
+	s := a.seq
+	a.seq++
+	return s
+}
+
+// encodeAPNG encodes the frames loaded by load to APNG with 8-bit RGBA.
+// Every frame covers the whole canvas with the size of the first frame.
+func encodeAPNG(w io.Writer, frames []Frame, load func(i int) (image.Image, error)) error {
+	a := &apngWriter{w: bufio.NewWriter(w)}
+	a.w.WriteString("\x89PNG\r\n\x1a\n")
+
+	var rect image.Rectangle
+	for i, fr := range frames {
+		img, err := load(i)
+		if err != nil {
+			return err
+		}
+		if i == 0 {
+			rect = image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy())
+			ihdr := make([]byte, 13)
+			binary.BigEndian.PutUint32(ihdr[0:], uint32(rect.Dx()))
+			binary.BigEndian.PutUint32(ihdr[4:], uint32(rect.Dy()))
+			// bit depth 8, color type RGBA
+			ihdr[8], ihdr[9] = 8, 6
+			a.chunk("IHDR", ihdr)
+
+			actl := make([]byte, 8)
+			binary.BigEndian.PutUint32(actl[0:], uint32(len(frames)))
+			// loop forever
+			binary.BigEndian.PutUint32(actl[4:], 0)
+			a.chunk("acTL", actl)
+		}
+
+		fctl := make([]byte, 26)
+		binary.BigEndian.PutUint32(fctl[0:], a.nextSeq())
+		binary.BigEndian.PutUint32(fctl[4:], uint32(rect.Dx()))
+		binary.BigEndian.PutUint32(fctl[8:], uint32(rect.Dy()))
+		// x and y offsets are 0
+		delay := fr.Delay
+		if delay > 0xffff {
+			delay = 0xffff
+		}
+		binary.BigEndian.PutUint16(fctl[20:], uint16(delay))
+		binary.BigEndian.PutUint16(fctl[22:], 1000)
+		// dispose op none, blend op source
+		fctl[24], fctl[25] = 0, 0
+		a.chunk("fcTL", fctl)
+
+		data, err := compressRGBA(img, rect)
+		if err != nil {
+			return err
+		}
+		if i == 0 {
+			a.chunk("IDAT", data)
+		} else {
+			fdat := make([]byte, 4+len(data))
+			binary.BigEndian.PutUint32(fdat, a.nextSeq())
+			copy(fdat[4:], data)
+			a.chunk("fdAT", fdat)
+		}
+	}
+	a.chunk("IEND", nil)
+	if a.err != nil {
+		return a.err
+	}
+	return a.w.Flush()
+}
+
+// compressRGBA returns the zlib compressed scanlines of img drawn on rect.
+// Each scanline uses the Sub filter.
+func compressRGBA(img image.Image, rect image.Rectangle) ([]byte, error) {
+	m := image.NewNRGBA(rect)
+	draw.Draw(m, rect, img, img.Bounds().Min, draw.Src)
+
+	b := &bytes.Buffer{}
+	zw, err := zlib.NewWriterLevel(b, zlib.BestSpeed)
+	if err != nil {
+		return nil, err
+	}
+	stride := rect.Dx() * 4
+	line := make([]byte, 1+stride)
+	for y := 0; y < rect.Dy(); y++ {
+		row := m.Pix[y*m.Stride : y*m.Stride+stride]
+		line[0] = 1
+		for x := 0; x < stride; x++ {
+			if x < 4 {
+				line[1+x] = row[x]
+			} else {
+				line[1+x] = row[x] - row[x-4]
+			}
+		}
+		if _, err := zw.Write(line); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
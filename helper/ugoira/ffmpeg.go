@@ -0,0 +1,80 @@
+package ugoira
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// convertFFmpeg extracts the frames to a temporary directory
+// and encodes them with the ffconcat demuxer of ffmpeg.
+func (c *Converter) convertFFmpeg(ctx context.Context, files map[string]*zip.File, frames []Frame, out string) error {
+	dir, err := ioutil.TempDir("", "bowerbird-ugoira-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	list := &strings.Builder{}
+	list.WriteString("ffconcat version 1.0\n")
+	for i, fr := range frames {
+		name := fmt.Sprintf("%06d%s", i, filepath.Ext(fr.File))
+		if err := extract(files[fr.File], filepath.Join(dir, name)); err != nil {
+			return err
+		}
+		fmt.Fprintf(list, "file '%s'\nduration %.3f\n", name, float64(fr.Delay)/1000)
+	}
+	// the duration of the last frame is ignored unless it is repeated
+	fmt.Fprintf(list, "file '%06d%s'\n", len(frames)-1, filepath.Ext(frames[len(frames)-1].File))
+	listFile := filepath.Join(dir, "frames.ffconcat")
+	if err := ioutil.WriteFile(listFile, []byte(list.String()), 0644); err != nil {
+		return err
+	}
+
+	args := []string{"-y", "-loglevel", "error", "-f", "concat", "-safe", "0", "-i", listFile, "-vsync", "vfr"}
+	switch c.Format {
+	case FormatWebM:
+		args = append(args, "-c:v", "libvpx-vp9", "-b:v", "0", "-crf", "30", "-pix_fmt", "yuv420p")
+	case FormatMP4:
+		// yuv420p requires even width and height
+		args = append(args, "-c:v", "libx264", "-crf", "18", "-pix_fmt", "yuv420p",
+			"-vf", "pad=ceil(iw/2)*2:ceil(ih/2)*2", "-movflags", "+faststart")
+	}
+	abs, err := filepath.Abs(out)
+	if err != nil {
+		return err
+	}
+	args = append(args, "-f", c.Format, abs)
+
+	cmd := exec.CommandContext(ctx, c.FFmpeg, args...)
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("ugoira: ffmpeg: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+func extract(zf *zip.File, dst string) error {
+	r, err := zf.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,129 @@
+// Package ugoira converts the frames of pixiv ugoira to animations.
+package ugoira
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"image"
+	"os"
+	"os/exec"
+
+	// decode frames in zip
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+// Formats of converted animations
+const (
+	FormatWebM = "webm"
+	FormatMP4  = "mp4"
+	FormatGIF  = "gif"
+	FormatAPNG = "apng"
+)
+
+// Frame is a frame of ugoira.
+type Frame struct {
+	// File is the name of the image in zip.
+	File string
+	// Delay is in milliseconds.
+	Delay int
+}
+
+// Converter converts the ugoira zip to animations.
+type Converter struct {
+	Format string
+	// FFmpeg is the path of ffmpeg, used for WebM and MP4.
+	FFmpeg string
+}
+
+func needsFFmpeg(format string) bool {
+	return format == FormatWebM || format == FormatMP4
+}
+
+// NewConverter returns a Converter of format.
+// The format falls back to fallback if it needs ffmpeg
+// but ffmpegCommand is not found. If fallback is empty then,
+// NewConverter returns nil and the zips are not converted.
+func NewConverter(format, fallback, ffmpegCommand string) (*Converter, error) {
+	c := &Converter{Format: format}
+	if ffmpegCommand != "" {
+		if p, err := exec.LookPath(ffmpegCommand); err == nil {
+			c.FFmpeg = p
+		}
+	}
+	if needsFFmpeg(c.Format) && c.FFmpeg == "" {
+		if fallback == "" {
+			return nil, nil
+		}
+		c.Format = fallback
+	}
+
+	switch c.Format {
+	case FormatWebM, FormatMP4:
+		if c.FFmpeg == "" {
+			return nil, fmt.Errorf("ugoira: ffmpeg is required for %s", c.Format)
+		}
+	case FormatGIF, FormatAPNG:
+	default:
+		return nil, fmt.Errorf("ugoira: unknown format: %q", c.Format)
+	}
+	return c, nil
+}
+
+// Ext returns the extension of converted files like ".webm".
+func (c *Converter) Ext() string {
+	if c.Format == FormatAPNG {
+		return ".png"
+	}
+	return "." + c.Format
+}
+
+// Convert converts the frames in zr to the animation file out.
+func (c *Converter) Convert(ctx context.Context, zr *zip.Reader, frames []Frame, out string) error {
+	if len(frames) == 0 {
+		return fmt.Errorf("ugoira: no frames")
+	}
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+	for _, fr := range frames {
+		if _, ok := files[fr.File]; !ok {
+			return fmt.Errorf("ugoira: frame %q not found in zip", fr.File)
+		}
+	}
+
+	if needsFFmpeg(c.Format) {
+		return c.convertFFmpeg(ctx, files, frames, out)
+	}
+
+	load := func(i int) (image.Image, error) {
+		r, err := files[frames[i].File].Open()
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		img, _, err := image.Decode(r)
+		if err != nil {
+			return nil, fmt.Errorf("ugoira: decoding frame %q: %w", frames[i].File, err)
+		}
+		return img, nil
+	}
+
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	if c.Format == FormatGIF {
+		err = encodeGIF(f, frames, load)
+	} else {
+		err = encodeAPNG(f, frames, load)
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
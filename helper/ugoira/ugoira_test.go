@@ -0,0 +1,115 @@
+package ugoira
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testZip(t *testing.T, colors ...color.Color) (*zip.Reader, []Frame) {
+	b := &bytes.Buffer{}
+	zw := zip.NewWriter(b)
+	frames := []Frame{}
+	for i, c := range colors {
+		img := image.NewRGBA(image.Rect(0, 0, 4, 3))
+		for y := 0; y < 3; y++ {
+			for x := 0; x < 4; x++ {
+				img.Set(x, y, c)
+			}
+		}
+		name := string(rune('0'+i)) + ".png"
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := png.Encode(w, img); err != nil {
+			t.Fatal(err)
+		}
+		frames = append(frames, Frame{File: name, Delay: 100 * (i + 1)})
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(b.Bytes()), int64(b.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return zr, frames
+}
+
+func TestConvert(t *testing.T) {
+	red := color.RGBA{255, 0, 0, 255}
+	blue := color.RGBA{0, 0, 255, 255}
+	zr, frames := testZip(t, red, blue)
+	dir := t.TempDir()
+
+	c, err := NewConverter(FormatWebM, FormatGIF, "bowerbird-no-such-ffmpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Format != FormatGIF {
+		t.Fatalf("format %q doesn't fall back to gif", c.Format)
+	}
+	out := filepath.Join(dir, "a"+c.Ext())
+	if err := c.Convert(context.Background(), zr, frames, out); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := gif.DecodeAll(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Image) != 2 || g.Delay[0] != 10 || g.Delay[1] != 20 {
+		t.Errorf("unexpected gif with %d frames and delays %v", len(g.Image), g.Delay)
+	}
+
+	c = &Converter{Format: FormatAPNG}
+	out = filepath.Join(dir, "a"+c.Ext())
+	if err := c.Convert(context.Background(), zr, frames, out); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// decoders without APNG support show the first frame
+	img, err := png.Decode(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, g, bl, _ := img.At(1, 1).RGBA(); r>>8 != 255 || g != 0 || bl != 0 {
+		t.Errorf("unexpected color of first frame: %v", img.At(1, 1))
+	}
+	if n := bytes.Count(b, []byte("fcTL")); n != 2 {
+		t.Errorf("%d fcTL chunks found", n)
+	}
+	if n := bytes.Count(b, []byte("fdAT")); n != 1 {
+		t.Errorf("%d fdAT chunks found", n)
+	}
+
+	if err := c.Convert(context.Background(), zr, []Frame{{File: "none.jpg"}}, out); err == nil {
+		t.Error("expected error of missing frame")
+	}
+}
+
+func TestNewConverterWithoutFallback(t *testing.T) {
+	c, err := NewConverter(FormatWebM, "", "bowerbird-no-such-ffmpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c != nil {
+		t.Fatalf("got converter of %q without ffmpeg and fallback", c.Format)
+	}
+}
@@ -48,6 +48,9 @@ const (
 	CollectionCollection = "collection"
 	CollectionTag        = "tags"
 	CollectionMedia      = "media"
+	CollectionCheckpoint = "checkpoints"
+	CollectionBookmark   = "bookmarks"
+	CollectionStatistic  = "statistics"
 )
 
 // ExtUser extends the User.
@@ -124,7 +127,8 @@ type CollectionSource string
 
 // Various CollectionSource
 const (
-	CollectionSourcePixivNovelSeries CollectionSource = "pixiv-novel-series"
+	CollectionSourcePixivNovelSeries  CollectionSource = "pixiv-novel-series"
+	CollectionSourcePixivIllustSeries CollectionSource = "pixiv-illust-series"
 )
 
 // Collection defines the collection of Post.
@@ -143,7 +147,54 @@ type Collection struct {
 }
 
 // ExtCollection extends the Collection.
-type ExtCollection struct{}
+type ExtCollection struct {
+	Pixiv *PixivSeries `bson:"pixiv,omitempty" json:"pixiv,omitempty"`
+}
+
+// Checkpoint records where the last crawling of a feed stopped.
+type Checkpoint struct {
+	ID     primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Source Source             `bson:"source" json:"source"`
+	// Feed is the name of feed like "illust-bookmarks".
+	Feed     string `bson:"feed" json:"feed"`
+	UserID   string `bson:"userID" json:"userID"`
+	Restrict string `bson:"restrict" json:"restrict,omitempty"`
+	// MaxBookmarkID and Offset are the query of the next page.
+	MaxBookmarkID int `bson:"maxBookmarkID,omitempty" json:"maxBookmarkID,omitempty"`
+	Offset        int `bson:"offset,omitempty" json:"offset,omitempty"`
+
+	LastModified time.Time `bson:"lastModified,omitempty" json:"lastModified,omitempty"`
+}
+
+// Bookmark records when a post was added to and removed from
+// the bookmarks of a user on the source.
+// A post added again after removed has a new Bookmark.
+type Bookmark struct {
+	ID       primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Source   PostSource         `bson:"source" json:"source"`
+	SourceID string             `bson:"sourceID" json:"sourceID"`
+	// UserID is the ID of the user who bookmarked the post on the source.
+	UserID   string `bson:"userID" json:"userID"`
+	Restrict string `bson:"restrict" json:"restrict,omitempty"`
+	// AddedAt is when the bookmark was found for the first time.
+	AddedAt time.Time `bson:"addedAt" json:"addedAt"`
+	// RemovedAt is when a full sync of the bookmarks found it removed.
+	RemovedAt time.Time `bson:"removedAt,omitempty" json:"removedAt,omitempty"`
+}
+
+// Statistic is a sample of the popularity of a Post or User
+// taken when it is crawled.
+type Statistic struct {
+	ID     primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	PostID primitive.ObjectID `bson:"postID,omitempty" json:"-"`
+	UserID primitive.ObjectID `bson:"userID,omitempty" json:"-"`
+	Date   time.Time          `bson:"date" json:"date"`
+	// Bookmarks is the total bookmarks of the post,
+	// or the total public bookmarks of the user.
+	Bookmarks int `bson:"bookmarks" json:"bookmarks"`
+	// Views is the total views of the post.
+	Views int `bson:"views,omitempty" json:"views,omitempty"`
+}
 
 // Tag defines the tag of the User, Post and Collection.
 type Tag struct {
@@ -157,10 +208,15 @@ type MediaType string
 
 // media types
 const (
-	MediaPixivAvatar            MediaType = "pixiv-avatar"
-	MediaPixivWorkspaceImage    MediaType = "pixiv-workspace-image"
-	MediaPixivIllust            MediaType = "pixiv-illust"
-	MediaPixivNovelCover        MediaType = "pixiv-novel-cover"
+	MediaPixivAvatar         MediaType = "pixiv-avatar"
+	MediaPixivWorkspaceImage MediaType = "pixiv-workspace-image"
+	MediaPixivIllust         MediaType = "pixiv-illust"
+	MediaPixivUgoira         MediaType = "pixiv-ugoira"
+	MediaPixivNovelCover     MediaType = "pixiv-novel-cover"
+	// MediaPixivNovelImage is the image uploaded to a novel, [uploadedimage:ID] in text.
+	MediaPixivNovelImage MediaType = "pixiv-novel-image"
+	// MediaPixivNovelIllust is the page of an illust in a novel, [pixivimage:ID-page] in text.
+	MediaPixivNovelIllust       MediaType = "pixiv-novel-illust"
 	MediaPixivProfileBackground MediaType = "pixiv-profile-background"
 )
 
@@ -171,11 +227,15 @@ type Media struct {
 	MIME      string             `bson:"mime,omitempty" json:"mime"`
 	Colors    []Color            `bson:"colors,omitempty" json:"colors"`
 	Size      int                `bson:"size,omitempty" json:"size"`
+	SHA256    string             `bson:"sha256,omitempty" json:"sha256,omitempty"`
 	Height    int                `bson:"height,omitempty" json:"height,omitempty"`
 	Width     int                `bson:"width,omitempty" json:"width,omitempty"`
 	URL       string             `bson:"url,omitempty" json:"-"`
 	Path      string             `bson:"path,omitempty" json:"-"`
 	Extension *ExtMedia          `bson:"extension,omitempty" json:"extension"`
+
+	// Gone is true if the file is removed from the source.
+	Gone bool `bson:"gone,omitempty" json:"gone,omitempty"`
 }
 
 // ExtMedia extends the media from various sources
@@ -16,6 +16,9 @@ func EnsureIndexes(ctx context.Context, db *mongo.Database) error {
 	cpd := db.Collection(CollectionPostDetail)
 	cm := db.Collection(CollectionMedia)
 	cc := db.Collection(CollectionCollection)
+	cck := db.Collection(CollectionCheckpoint)
+	cb := db.Collection(CollectionBookmark)
+	cs := db.Collection(CollectionStatistic)
 
 	_, err := cu.Indexes().CreateOne(
 		ctx, mongo.IndexModel{
@@ -75,10 +78,15 @@ func EnsureIndexes(ctx context.Context, db *mongo.Database) error {
 		return err
 	}
 
-	_, err = cm.Indexes().CreateOne(
-		ctx, mongo.IndexModel{
-			Keys:    d{{Key: "url", Value: 1}},
-			Options: options.Index().SetUnique(true),
+	_, err = cm.Indexes().CreateMany(
+		ctx, []mongo.IndexModel{
+			{
+				Keys:    d{{Key: "url", Value: 1}},
+				Options: options.Index().SetUnique(true),
+			},
+			{
+				Keys: d{{Key: "sha256", Value: 1}},
+			},
 		},
 	)
 	if err != nil {
@@ -95,5 +103,49 @@ func EnsureIndexes(ctx context.Context, db *mongo.Database) error {
 			},
 		},
 	)
+	if err != nil {
+		return err
+	}
+
+	_, err = cck.Indexes().CreateOne(
+		ctx, mongo.IndexModel{
+			Keys: d{
+				{Key: "source", Value: 1}, {Key: "feed", Value: 1},
+				{Key: "userID", Value: 1}, {Key: "restrict", Value: 1},
+			},
+			Options: options.Index().SetUnique(true),
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = cb.Indexes().CreateMany(
+		ctx, []mongo.IndexModel{
+			{
+				Keys: d{
+					{Key: "source", Value: 1}, {Key: "userID", Value: 1},
+					{Key: "restrict", Value: 1}, {Key: "sourceID", Value: 1},
+				},
+			},
+			{
+				Keys: d{{Key: "removedAt", Value: 1}},
+			},
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = cs.Indexes().CreateMany(
+		ctx, []mongo.IndexModel{
+			{
+				Keys: d{{Key: "postID", Value: 1}, {Key: "date", Value: 1}},
+			},
+			{
+				Keys: d{{Key: "userID", Value: 1}, {Key: "date", Value: 1}},
+			},
+		},
+	)
 	return err
 }
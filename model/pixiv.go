@@ -42,9 +42,12 @@ type PixivPost struct {
 // PixivIllustDetail extends PostDetail with Pixiv's illust struct
 type PixivIllustDetail struct {
 	// Type can be "illust", "manga" or "ugoira"
-	Type        string `bson:"type,omitempty" json:"type,omitempty"`
-	CaptionHTML string `bson:"captionHTML,omitempty" json:"captionHTML,omitempty"`
-	Title       string `bson:"title,omitempty" json:"title,omitempty"`
+	Type        string             `bson:"type,omitempty" json:"type,omitempty"`
+	CaptionHTML string             `bson:"captionHTML,omitempty" json:"captionHTML,omitempty"`
+	Title       string             `bson:"title,omitempty" json:"title,omitempty"`
+	SeriesID    primitive.ObjectID `bson:"seriesID,omitempty" json:"seriesID,omitempty"`
+	// UgoiraMediaID is the ID of the zip of ugoira, which is not a page in MediaIDs.
+	UgoiraMediaID primitive.ObjectID `bson:"ugoiraMediaID,omitempty" json:"-"`
 }
 
 // PixivNovelDetail extends PostDetail with Pixiv's novel struct
@@ -53,9 +56,33 @@ type PixivNovelDetail struct {
 	Title       string             `bson:"title,omitempty" json:"title,omitempty"`
 	Text        string             `bson:"text,omitempty" json:"text,omitempty"`
 	SeriesID    primitive.ObjectID `bson:"seriesID,omitempty" json:"seriesID,omitempty"`
+	// Chapters are the [chapter:] in Text.
+	Chapters  []NovelChapter `bson:"chapters,omitempty" json:"chapters,omitempty"`
+	PageCount int            `bson:"pageCount,omitempty" json:"pageCount,omitempty"`
+}
+
+// NovelChapter is a chapter in the text of novel
+type NovelChapter struct {
+	Title string `bson:"title" json:"title"`
+	// Page is the page of the chapter, starting from 1.
+	Page int `bson:"page" json:"page"`
+}
+
+// PixivSeries extends Collection with the detail of Pixiv's series
+type PixivSeries struct {
+	Caption      string `bson:"caption,omitempty" json:"caption,omitempty"`
+	IsConcluded  bool   `bson:"isConcluded" json:"isConcluded"`
+	ContentCount int    `bson:"contentCount" json:"contentCount"`
 }
 
 // PixivMedia extends Media with extra info of Pixiv images, especially Ugoiras
 type PixivMedia struct {
 	UgoiraDelay []int `bson:"ugoiraDelay,omitempty" json:"ugoiraDelay,omitempty"`
+	// UgoiraFrames are the names of frames in the zip.
+	UgoiraFrames []string `bson:"ugoiraFrames,omitempty" json:"ugoiraFrames,omitempty"`
+	// ConvertedPath is the path of the animation converted from the ugoira zip.
+	ConvertedPath string `bson:"convertedPath,omitempty" json:"-"`
+	// NovelImage is the ID in the marker of the image in novel text,
+	// like "12345" of [uploadedimage:12345] or "67890-2" of [pixivimage:67890-2].
+	NovelImage string `bson:"novelImage,omitempty" json:"novelImage,omitempty"`
 }
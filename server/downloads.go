@@ -0,0 +1,122 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/WOo0W/bowerbird/config"
+	pixivh "github.com/WOo0W/bowerbird/helper/pixiv"
+	"github.com/labstack/echo/v4"
+)
+
+type downloadTask struct {
+	ID           int64  `json:"id"`
+	Group        string `json:"group,omitempty"`
+	URL          string `json:"url"`
+	LocalPath    string `json:"localPath"`
+	Status       string `json:"status"`
+	BytesLastSec int64  `json:"bytesLastSec"`
+	Err          string `json:"err,omitempty"`
+}
+
+type downloadControlOptions struct {
+	IDs   []int64 `json:"ids"`
+	Group string  `json:"group"`
+}
+
+func (h *handler) downloadTasks(c echo.Context) error {
+	ts := h.dl.TaskSnapshots()
+	a := make([]downloadTask, 0, len(ts))
+	for _, t := range ts {
+		dt := downloadTask{
+			ID:           t.ID,
+			Group:        t.Group,
+			URL:          t.URL,
+			LocalPath:    t.LocalPath,
+			Status:       t.Status.String(),
+			BytesLastSec: t.BytesLastSec,
+		}
+		if t.Err != nil {
+			dt.Err = t.Err.Error()
+		}
+		a = append(a, dt)
+	}
+	return c.JSON(http.StatusOK, a)
+}
+
+// downloadControl pauses, resumes or cancels the tasks of the downloader in this process.
+// The tasks of a crawl running in another process cannot be reached,
+// since it holds the download journal by itself.
+func (h *handler) downloadControl(c echo.Context) error {
+	o := &downloadControlOptions{}
+	if err := c.Bind(o); err != nil {
+		return err
+	}
+	ids := o.IDs
+	if o.Group != "" {
+		ids = append(ids, h.dl.GroupIDs(o.Group)...)
+	}
+
+	var n int
+	switch c.Param("action") {
+	case "pause":
+		n = h.dl.Pause(ids...)
+	case "resume":
+		n = h.dl.Resume(ids...)
+	case "cancel":
+		n = h.dl.Cancel(ids...)
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, "unknown action: "+c.Param("action"))
+	}
+	return c.JSON(http.StatusOK, map[string]int{"affected": n})
+}
+
+type rateLimits struct {
+	RateLimit      string            `json:"rateLimit"`
+	HostRateLimits map[string]string `json:"hostRateLimits"`
+}
+
+func (h *handler) downloadRateLimits(c echo.Context) error {
+	return c.JSON(http.StatusOK, &rateLimits{
+		RateLimit:      h.conf.Downloader.RateLimit,
+		HostRateLimits: h.conf.Downloader.HostRateLimits,
+	})
+}
+
+// setDownloadRateLimits changes the rate limits of the downloader
+// and saves them to the config.
+func (h *handler) setDownloadRateLimits(c echo.Context) error {
+	r := &rateLimits{}
+	if err := c.Bind(r); err != nil {
+		return err
+	}
+	dc := config.DownloaderConfig{
+		RateLimit:      r.RateLimit,
+		HostRateLimits: r.HostRateLimits,
+	}
+	global, err := dc.ParsedRateLimit()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	hosts, err := dc.ParsedHostRateLimits()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	h.dl.SetRateLimits(global, hosts)
+
+	h.conf.Downloader.RateLimit = dc.RateLimit
+	h.conf.Downloader.HostRateLimits = dc.HostRateLimits
+	if err := h.conf.Save(); err != nil {
+		c.Logger().Warn("cannot save config: ", err)
+	}
+	return c.JSON(http.StatusOK, r)
+}
+
+// downloadRestore adds the unfinished tasks in the journal to the queue.
+func (h *handler) downloadRestore(c echo.Context) error {
+	// the tasks live longer than the request
+	n, err := pixivh.ResumeTasks(h.ctx, h.dl, h.parsedPixivDir, h.db)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, map[string]int{"affected": n})
+}
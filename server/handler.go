@@ -2,6 +2,7 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"image"
 	"image/jpeg"
@@ -12,18 +13,21 @@ import (
 
 	"github.com/disintegration/imaging"
 
+	"errors"
 	"io"
+	"mime"
 	"net/http"
 	"net/url"
-	"os"
 	"path"
-	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/WOo0W/bowerbird/config"
+	"github.com/WOo0W/bowerbird/downloader"
 	"github.com/WOo0W/bowerbird/helper"
 	"github.com/WOo0W/bowerbird/helper/orderedmap"
 	"github.com/WOo0W/bowerbird/model"
+	"github.com/WOo0W/bowerbird/storage"
 	"github.com/labstack/echo/v4"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -37,10 +41,13 @@ type (
 )
 
 type handler struct {
+	ctx              context.Context
 	db               *mongo.Database
+	dl               *downloader.Downloader
 	conf             *config.Config
 	clientPximg      *http.Client
 	parsedPixivDir   string
+	pixivStorage     storage.Storage
 	findUserPipeline a
 	findPostPipeline a
 }
@@ -70,11 +77,10 @@ func (h *handler) apiVersion(c echo.Context) error {
 	return c.String(200, "bowerbird "+config.Version)
 }
 
-func openImage(fullPath string) (image.Image, error) {
-	f, err := os.Open(fullPath)
-
+func openImage(ctx context.Context, s storage.Storage, name string) (image.Image, error) {
+	f, err := s.Open(ctx, name)
 	if err != nil {
-		if os.IsNotExist(err) {
+		if errors.Is(err, storage.ErrNotExist) {
 			return nil, echo.ErrNotFound.SetInternal(err)
 		}
 		return nil, err
@@ -83,8 +89,9 @@ func openImage(fullPath string) (image.Image, error) {
 	return imaging.Decode(f)
 }
 
-func sendTempThumbnail(c echo.Context, fullPath string, width, height int) error {
-	fi, err := os.Stat(fullPath)
+func sendTempThumbnail(c echo.Context, s storage.Storage, name string, width, height int) error {
+	ctx := c.Request().Context()
+	fi, err := s.Stat(ctx, name)
 	if err != nil {
 		return echo.NotFoundHandler(c)
 	}
@@ -93,8 +100,8 @@ func sendTempThumbnail(c echo.Context, fullPath string, width, height int) error
 
 	// To take advantages of http.ServeContent we builds a LazyReadSeeker
 	r := helper.NewLazyReadSeeker(func() (io.ReadSeeker, error) {
-		c.Logger().Warn(fmt.Sprintf("making thumbnail for %s with size %dx%d", fullPath, width, height))
-		img, err := openImage(fullPath)
+		c.Logger().Warn(fmt.Sprintf("making thumbnail for %s with size %dx%d", name, width, height))
+		img, err := openImage(ctx, s, name)
 		if err != nil {
 			return nil, err
 		}
@@ -105,7 +112,41 @@ func sendTempThumbnail(c echo.Context, fullPath string, width, height int) error
 		})
 		return bytes.NewReader(b.Bytes()), err
 	})
-	http.ServeContent(c.Response(), c.Request(), "", fi.ModTime(), r)
+	http.ServeContent(c.Response(), c.Request(), "", fi.ModTime, r)
+	return err
+}
+
+// sendFile sends the file in s. Range requests are supported
+// if the file can be seeked, like the files on local disk.
+func sendFile(c echo.Context, s storage.Storage, name string) error {
+	ctx := c.Request().Context()
+	fi, err := s.Stat(ctx, name)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotExist) {
+			return echo.ErrNotFound.SetInternal(err)
+		}
+		return err
+	}
+	f, err := s.Open(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if rs, ok := f.(io.ReadSeeker); ok {
+		http.ServeContent(c.Response(), c.Request(), name, fi.ModTime, rs)
+		return nil
+	}
+	header := c.Response().Header()
+	if t := mime.TypeByExtension(path.Ext(name)); t != "" {
+		header.Set("Content-Type", t)
+	}
+	header.Set("Content-Length", strconv.FormatInt(fi.Size, 10))
+	if !fi.ModTime.IsZero() {
+		header.Set("Last-Modified", fi.ModTime.UTC().Format(http.TimeFormat))
+	}
+	c.Response().WriteHeader(http.StatusOK)
+	_, err = io.Copy(c.Response(), f)
 	return err
 }
 
@@ -123,11 +164,11 @@ func (h *handler) localMediaPixiv(c echo.Context) error {
 	if err != nil {
 		return err
 	}
-	fullPath := filepath.Join(h.parsedPixivDir, path.Clean("/"+p)) // "/"+ for security
+	name := storage.CleanName(p) // for security
 	if q.Width != 0 && q.Height != 0 {
-		return sendTempThumbnail(c, fullPath, q.Width, q.Height)
+		return sendTempThumbnail(c, h.pixivStorage, name, q.Width, q.Height)
 	}
-	return c.File(fullPath)
+	return sendFile(c, h.pixivStorage, name)
 }
 
 type dbFindOptions struct {
@@ -277,24 +318,20 @@ func (h *handler) mediaByID(c echo.Context) error {
 	f, ok := r.Lookup("path").StringValueOK()
 	ff := ""
 	switch t := model.MediaType(r.Lookup("type").StringValue()); t {
-	case model.MediaPixivIllust:
-		ff = filepath.Join(h.parsedPixivDir, f)
-		f = "pixiv/" + f
-	case model.MediaPixivAvatar:
-		ff = filepath.Join(h.parsedPixivDir, "avatars", f)
-		f = "pixiv/" + f
-	case model.MediaPixivProfileBackground:
-		ff = filepath.Join(h.parsedPixivDir, "profile_background", f)
-		f = "pixiv/" + f
-	case model.MediaPixivWorkspaceImage:
-		ff = filepath.Join("workspace_images", f)
+	// images of user profiles are saved under
+	// avatars/, profile_background/ and workspace_images/,
+	// and images of novels under novel_covers/ and novel_images/
+	case model.MediaPixivIllust, model.MediaPixivUgoira, model.MediaPixivAvatar,
+		model.MediaPixivProfileBackground, model.MediaPixivWorkspaceImage,
+		model.MediaPixivNovelCover, model.MediaPixivNovelImage, model.MediaPixivNovelIllust:
+		ff = f
 		f = "pixiv/" + f
 	default:
 		ff = f
 		ok = false
 	}
 	if ok {
-		if _, err := os.Stat(ff); err == nil {
+		if _, err := h.pixivStorage.Stat(ctx, ff); err == nil {
 			return c.Redirect(http.StatusTemporaryRedirect,
 				"/api/v1/local/"+f)
 		}
@@ -0,0 +1,36 @@
+package server
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/WOo0W/bowerbird/helper/history"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// versions sends the detail versions of the post or user
+// with the ID in param :id found with find.
+func (h *handler) versions(c echo.Context, find func(context.Context, *mongo.Database, primitive.ObjectID) ([]*history.Version, error)) error {
+	oid, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return &echo.HTTPError{
+			Code:    http.StatusBadRequest,
+			Message: err,
+		}
+	}
+	vs, err := find(c.Request().Context(), h.db, oid)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, vs)
+}
+
+func (h *handler) postVersions(c echo.Context) error {
+	return h.versions(c, history.PostVersions)
+}
+
+func (h *handler) userVersions(c echo.Context) error {
+	return h.versions(c, history.UserVersions)
+}
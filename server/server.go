@@ -1,11 +1,14 @@
 package server
 
 import (
+	"context"
 	"net/http"
 	"strings"
 
 	"github.com/WOo0W/bowerbird/config"
+	"github.com/WOo0W/bowerbird/downloader"
 	"github.com/WOo0W/bowerbird/helper"
+	"github.com/WOo0W/bowerbird/storage"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -27,8 +30,9 @@ func errHandler(err error, c echo.Context) {
 	}
 }
 
-// Serve runs a new bowerbird server with the given config
-func Serve(conf *config.Config, db *mongo.Database) error {
+// Serve runs a new bowerbird server with the given config.
+// The download tasks of dl can be controlled via API if dl is not nil.
+func Serve(ctx context.Context, conf *config.Config, db *mongo.Database, dl *downloader.Downloader) error {
 	e := echo.New()
 	e.Debug = true
 
@@ -49,11 +53,18 @@ func Serve(conf *config.Config, db *mongo.Database) error {
 	if err != nil {
 		return err
 	}
+	ps, err := storage.ForSource(&conf.Storage, "pixiv", conf.Storage.ParsedPixiv())
+	if err != nil {
+		return err
+	}
 	h := &handler{
+		ctx:            ctx,
 		db:             db,
+		dl:             dl,
 		conf:           conf,
 		clientPximg:    &http.Client{Transport: pdltr},
 		parsedPixivDir: conf.Storage.ParsedPixiv(),
+		pixivStorage:   ps,
 	}
 	e.GET("/api", h.apiVersion)
 
@@ -69,6 +80,19 @@ func Serve(conf *config.Config, db *mongo.Database) error {
 	e.POST("/api/v1/user/find", h.findUser)
 	e.POST("/api/v1/post/find", h.findPost)
 
+	e.GET("/api/v1/user/:id/statistics", h.userStatistics)
+	e.GET("/api/v1/post/:id/statistics", h.postStatistics)
+	e.GET("/api/v1/user/:id/versions", h.userVersions)
+	e.GET("/api/v1/post/:id/versions", h.postVersions)
+
+	if dl != nil {
+		e.GET("/api/v1/downloads", h.downloadTasks)
+		e.GET("/api/v1/downloads/rate-limit", h.downloadRateLimits)
+		e.PUT("/api/v1/downloads/rate-limit", h.setDownloadRateLimits)
+		e.POST("/api/v1/downloads/restore", h.downloadRestore)
+		e.POST("/api/v1/downloads/:action", h.downloadControl)
+	}
+
 	e.HTTPErrorHandler = errHandler
 	return e.Start(conf.Server.Address)
 }
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/WOo0W/bowerbird/model"
+	"github.com/labstack/echo/v4"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// statistics sends the statistics of the post or user
+// with the ID in param :id in date order.
+func (h *handler) statistics(c echo.Context, key string) error {
+	ctx := c.Request().Context()
+	oid, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		return &echo.HTTPError{
+			Code:    http.StatusBadRequest,
+			Message: err,
+		}
+	}
+	cur, err := h.db.Collection(model.CollectionStatistic).Find(ctx,
+		d{{Key: key, Value: oid}},
+		options.Find().SetSort(d{{Key: "date", Value: 1}}))
+	if err != nil {
+		return err
+	}
+	ss := []model.Statistic{}
+	if err := cur.All(ctx, &ss); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, ss)
+}
+
+func (h *handler) postStatistics(c echo.Context) error {
+	return h.statistics(c, "postID")
+}
+
+func (h *handler) userStatistics(c echo.Context) error {
+	return h.statistics(c, "userID")
+}
@@ -0,0 +1,161 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Local stores the files in a directory on the local disk.
+type Local struct {
+	Root string
+}
+
+// NewLocal returns a Local storage with the root directory.
+func NewLocal(root string) *Local {
+	return &Local{Root: root}
+}
+
+// Path returns the local path of the file.
+func (l *Local) Path(name string) string {
+	return filepath.Join(l.Root, filepath.FromSlash(CleanName(name)))
+}
+
+// Stat implements Storage.
+func (l *Local) Stat(ctx context.Context, name string) (*FileInfo, error) {
+	fi, err := os.Stat(l.Path(name))
+	if err != nil {
+		return nil, err
+	}
+	return &FileInfo{Name: CleanName(name), Size: fi.Size(), ModTime: fi.ModTime()}, nil
+}
+
+// Open implements Storage. The returned reader is an *os.File.
+func (l *Local) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	return os.Open(l.Path(name))
+}
+
+// Put implements Storage.
+func (l *Local) Put(ctx context.Context, name string, r io.ReadSeeker) error {
+	p := l.Path(name)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	tmp := p + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, p)
+}
+
+// Move implements Mover.
+func (l *Local) Move(ctx context.Context, localPath, name string) error {
+	p := l.Path(name)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	err := os.Rename(localPath, p)
+	var le *os.LinkError
+	if errors.As(err, &le) && le.Err == errCrossDevice {
+		// the root is on another filesystem
+		return copyAndRemove(localPath, p)
+	}
+	return err
+}
+
+// copyAndRemove copies the file src to dst with fsync and removes src.
+func copyAndRemove(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp := dst + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	in.Close()
+	return os.Remove(src)
+}
+
+// Remove implements Storage.
+func (l *Local) Remove(ctx context.Context, name string) error {
+	return os.Remove(l.Path(name))
+}
+
+// Rename implements Storage. The empty directories left are removed.
+func (l *Local) Rename(ctx context.Context, oldName, newName string) error {
+	op, np := l.Path(oldName), l.Path(newName)
+	if _, err := os.Lstat(np); err == nil {
+		return &os.LinkError{Op: "rename", Old: op, New: np, Err: ErrExist}
+	}
+	if err := os.MkdirAll(filepath.Dir(np), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(op, np); err != nil {
+		return err
+	}
+	root := filepath.Clean(l.Root)
+	for dir := filepath.Dir(op); dir != root && strings.HasPrefix(dir, root); dir = filepath.Dir(dir) {
+		// fails if the directory is not empty
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// Walk implements Storage.
+func (l *Local) Walk(ctx context.Context, fn func(fi *FileInfo) error) error {
+	return filepath.Walk(l.Root, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(l.Root, p)
+		if err != nil {
+			return err
+		}
+		return fn(&FileInfo{Name: filepath.ToSlash(rel), Size: fi.Size(), ModTime: fi.ModTime()})
+	})
+}
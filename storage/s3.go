@@ -0,0 +1,172 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/WOo0W/bowerbird/config"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// S3 stores the files as objects in a bucket of S3-compatible services.
+type S3 struct {
+	client *s3.S3
+	bucket string
+	prefix string
+}
+
+// NewS3 returns a S3 storage with the endpoint, bucket and credentials in bc.
+// The default credentials of AWS SDK are used if bc.Username is empty.
+func NewS3(bc *config.BackendConfig) (*S3, error) {
+	if bc.Bucket == "" {
+		return nil, fmt.Errorf("s3: no bucket specified")
+	}
+	region := bc.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	cfg := aws.NewConfig().
+		WithRegion(region).
+		WithS3ForcePathStyle(bc.PathStyle)
+	if bc.URL != "" {
+		cfg = cfg.WithEndpoint(bc.URL)
+	}
+	if bc.Username != "" {
+		cfg = cfg.WithCredentials(credentials.NewStaticCredentials(bc.Username, bc.Password, ""))
+	}
+	sess, err := session.NewSession(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := strings.Trim(bc.Prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	return &S3{client: s3.New(sess), bucket: bc.Bucket, prefix: prefix}, nil
+}
+
+func (s *S3) key(name string) string {
+	return s.prefix + CleanName(name)
+}
+
+func s3Error(op, name string, err error) error {
+	if rf, ok := err.(awserr.RequestFailure); ok && rf.StatusCode() == http.StatusNotFound {
+		return fmt.Errorf("s3: %s %q: %w", op, name, ErrNotExist)
+	}
+	return fmt.Errorf("s3: %s %q: %w", op, name, err)
+}
+
+// Stat implements Storage.
+func (s *S3) Stat(ctx context.Context, name string) (*FileInfo, error) {
+	out, err := s.client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(name)),
+	})
+	if err != nil {
+		return nil, s3Error("HeadObject", name, err)
+	}
+	return &FileInfo{
+		Name:    CleanName(name),
+		Size:    aws.Int64Value(out.ContentLength),
+		ModTime: aws.TimeValue(out.LastModified),
+	}, nil
+}
+
+// Open implements Storage.
+func (s *S3) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	out, err := s.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(name)),
+	})
+	if err != nil {
+		return nil, s3Error("GetObject", name, err)
+	}
+	return out.Body, nil
+}
+
+// Put implements Storage.
+func (s *S3) Put(ctx context.Context, name string, r io.ReadSeeker) error {
+	in := &s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(name)),
+		Body:   r,
+	}
+	if t := mime.TypeByExtension(path.Ext(name)); t != "" {
+		in.ContentType = aws.String(t)
+	}
+	if _, err := s.client.PutObjectWithContext(ctx, in); err != nil {
+		return s3Error("PutObject", name, err)
+	}
+	return nil
+}
+
+// Remove implements Storage.
+func (s *S3) Remove(ctx context.Context, name string) error {
+	_, err := s.client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(name)),
+	})
+	if err != nil {
+		return s3Error("DeleteObject", name, err)
+	}
+	return nil
+}
+
+// Rename implements Storage by copying and deleting the object.
+func (s *S3) Rename(ctx context.Context, oldName, newName string) error {
+	if _, err := s.Stat(ctx, newName); err == nil {
+		return fmt.Errorf("s3: rename %q to %q: %w", oldName, newName, ErrExist)
+	} else if !errors.Is(err, ErrNotExist) {
+		return err
+	}
+	src := &url.URL{Path: s.bucket + "/" + s.key(oldName)}
+	_, err := s.client.CopyObjectWithContext(ctx, &s3.CopyObjectInput{
+		Bucket:     aws.String(s.bucket),
+		Key:        aws.String(s.key(newName)),
+		CopySource: aws.String(src.EscapedPath()),
+	})
+	if err != nil {
+		return s3Error("CopyObject", oldName, err)
+	}
+	return s.Remove(ctx, oldName)
+}
+
+// Walk implements Storage.
+func (s *S3) Walk(ctx context.Context, fn func(fi *FileInfo) error) error {
+	var ferr error
+	err := s.client.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(s.prefix),
+	}, func(out *s3.ListObjectsV2Output, last bool) bool {
+		for _, o := range out.Contents {
+			ferr = fn(&FileInfo{
+				Name:    strings.TrimPrefix(aws.StringValue(o.Key), s.prefix),
+				Size:    aws.Int64Value(o.Size),
+				ModTime: aws.TimeValue(o.LastModified),
+			})
+			if ferr != nil {
+				return false
+			}
+		}
+		return true
+	})
+	if ferr != nil {
+		return ferr
+	}
+	if err != nil {
+		return s3Error("ListObjectsV2", s.prefix, err)
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package storage
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/WOo0W/bowerbird/config"
+)
+
+// fakeS3 is a stand-in of S3 serving a single bucket with path-style requests.
+type fakeS3 struct {
+	bucket string
+
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+type fakeS3Object struct {
+	Key          string `xml:"Key"`
+	Size         int64  `xml:"Size"`
+	LastModified string `xml:"LastModified"`
+}
+
+type fakeS3List struct {
+	XMLName     xml.Name       `xml:"ListBucketResult"`
+	Name        string         `xml:"Name"`
+	Prefix      string         `xml:"Prefix"`
+	KeyCount    int            `xml:"KeyCount"`
+	IsTruncated bool           `xml:"IsTruncated"`
+	Contents    []fakeS3Object `xml:"Contents"`
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	p := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	if p[0] != f.bucket {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("<Error><Code>NoSuchBucket</Code></Error>"))
+		return
+	}
+	now := time.Now().UTC()
+
+	if len(p) == 1 || p[1] == "" {
+		prefix := r.URL.Query().Get("prefix")
+		l := &fakeS3List{Name: f.bucket, Prefix: prefix}
+		for k, b := range f.objects {
+			if strings.HasPrefix(k, prefix) {
+				l.Contents = append(l.Contents, fakeS3Object{
+					Key: k, Size: int64(len(b)), LastModified: now.Format(time.RFC3339),
+				})
+			}
+		}
+		sort.Slice(l.Contents, func(i, j int) bool { return l.Contents[i].Key < l.Contents[j].Key })
+		l.KeyCount = len(l.Contents)
+		w.Header().Set("Content-Type", "application/xml")
+		xml.NewEncoder(w).Encode(l)
+		return
+	}
+
+	key := p[1]
+	switch r.Method {
+	case http.MethodPut:
+		if src := r.Header.Get("X-Amz-Copy-Source"); src != "" {
+			src, _ = url.PathUnescape(src)
+			b, ok := f.objects[strings.TrimPrefix(src, "/"+f.bucket+"/")]
+			if !ok {
+				b, ok = f.objects[strings.TrimPrefix(src, f.bucket+"/")]
+			}
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte("<Error><Code>NoSuchKey</Code></Error>"))
+				return
+			}
+			f.objects[key] = b
+			w.Write([]byte("<CopyObjectResult><ETag>\"0\"</ETag></CopyObjectResult>"))
+			return
+		}
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.objects[key] = b
+	case http.MethodGet, http.MethodHead:
+		b, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			if r.Method == http.MethodGet {
+				w.Write([]byte("<Error><Code>NoSuchKey</Code></Error>"))
+			}
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+		w.Header().Set("Last-Modified", now.Format(http.TimeFormat))
+		if r.Method == http.MethodGet {
+			w.Write(b)
+		}
+	case http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func TestS3(t *testing.T) {
+	f := &fakeS3{bucket: "archive", objects: map[string][]byte{}}
+	ts := httptest.NewServer(f)
+	defer ts.Close()
+
+	s, err := NewS3(&config.BackendConfig{
+		Type:      config.BackendS3,
+		URL:       ts.URL,
+		Username:  "key",
+		Password:  "secret",
+		Bucket:    "archive",
+		Prefix:    "/pixiv/",
+		PathStyle: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStorage(t, s)
+
+	if _, ok := f.objects["pixiv/avatars/user.png"]; !ok {
+		t.Error("objects are not stored with prefix")
+	}
+}
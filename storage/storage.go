@@ -0,0 +1,84 @@
+// Package storage provides the backends where the downloaded files are stored.
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/WOo0W/bowerbird/config"
+)
+
+// Errors returned by Storage
+var (
+	ErrNotExist = os.ErrNotExist
+	ErrExist    = os.ErrExist
+)
+
+// FileInfo describes a file in Storage.
+type FileInfo struct {
+	// Name is the slash-separated path relative to the root of Storage.
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// Storage is where the files of a source are stored.
+// The names of files are slash-separated paths like "123/123_p0.jpg".
+type Storage interface {
+	// Stat returns the FileInfo of the file, or ErrNotExist.
+	Stat(ctx context.Context, name string) (*FileInfo, error)
+	// Open opens the file for reading. The reader is also an io.Seeker if possible.
+	Open(ctx context.Context, name string) (io.ReadCloser, error)
+	// Put writes the content of r to the file, replacing the existing one.
+	Put(ctx context.Context, name string, r io.ReadSeeker) error
+	// Remove removes the file.
+	Remove(ctx context.Context, name string) error
+	// Rename moves the file to newName. It fails if newName exists.
+	Rename(ctx context.Context, oldName, newName string) error
+	// Walk calls fn with every file in Storage.
+	Walk(ctx context.Context, fn func(fi *FileInfo) error) error
+}
+
+// Mover is implemented by Storage on the local disk.
+// It moves the local file into Storage without copying.
+type Mover interface {
+	Move(ctx context.Context, localPath, name string) error
+}
+
+// CleanName cleans the name and prevents it from going out of the root.
+func CleanName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// New builds the Storage from bc. localDir is the local directory of the source
+// used when bc is nil or the backend is local without URL.
+func New(bc *config.BackendConfig, localDir string) (Storage, error) {
+	if bc == nil {
+		return NewLocal(localDir), nil
+	}
+	switch bc.Type {
+	case "", config.BackendLocal:
+		if bc.URL != "" {
+			return NewLocal(bc.URL), nil
+		}
+		return NewLocal(localDir), nil
+	case config.BackendWebDAV:
+		return NewWebDAV(bc.URL, bc.Username, bc.Password, nil)
+	case config.BackendS3:
+		return NewS3(bc)
+	}
+	return nil, fmt.Errorf("unknown storage backend type: %q", bc.Type)
+}
+
+// ForSource builds the Storage of the source like "pixiv" from c.
+func ForSource(c *config.StorageConfig, source, localDir string) (Storage, error) {
+	if bc, ok := c.Backends[source]; ok {
+		return New(&bc, localDir)
+	}
+	return New(nil, localDir)
+}
@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// testStorage puts, reads, lists and removes files in s.
+func testStorage(t *testing.T, s Storage) {
+	ctx := context.Background()
+	files := map[string]string{
+		"1/1_p0.jpg":       "page 0",
+		"1/1_p1.png":       "page 1",
+		"avatars/user.png": "avatar",
+		"a b/c%d.txt":      "escaped",
+	}
+	for name, content := range files {
+		if err := s.Put(ctx, name, strings.NewReader(content)); err != nil {
+			t.Fatal("Put:", name, err)
+		}
+	}
+	if err := s.Put(ctx, "1/1_p0.jpg", strings.NewReader("page 0 again")); err != nil {
+		t.Fatal("Put again:", err)
+	}
+	files["1/1_p0.jpg"] = "page 0 again"
+
+	for name, content := range files {
+		fi, err := s.Stat(ctx, name)
+		if err != nil {
+			t.Fatal("Stat:", name, err)
+		}
+		if fi.Size != int64(len(content)) {
+			t.Errorf("Stat %q: size %d, want %d", name, fi.Size, len(content))
+		}
+		r, err := s.Open(ctx, name)
+		if err != nil {
+			t.Fatal("Open:", name, err)
+		}
+		b, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal("Read:", name, err)
+		}
+		if string(b) != content {
+			t.Errorf("Open %q: got %q, want %q", name, b, content)
+		}
+	}
+
+	if _, err := s.Stat(ctx, "1/none.jpg"); !errors.Is(err, ErrNotExist) {
+		t.Errorf("Stat of missing file: got %v", err)
+	}
+	if _, err := s.Open(ctx, "1/none.jpg"); !errors.Is(err, ErrNotExist) {
+		t.Errorf("Open of missing file: got %v", err)
+	}
+
+	names := []string{}
+	err := s.Walk(ctx, func(fi *FileInfo) error {
+		names = append(names, fi.Name)
+		return nil
+	})
+	if err != nil {
+		t.Fatal("Walk:", err)
+	}
+	sort.Strings(names)
+	want := []string{"1/1_p0.jpg", "1/1_p1.png", "a b/c%d.txt", "avatars/user.png"}
+	if strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Errorf("Walk: got %v, want %v", names, want)
+	}
+
+	if err := s.Rename(ctx, "a b/c%d.txt", "renamed/c%d ?.txt"); err != nil {
+		t.Fatal("Rename:", err)
+	}
+	if _, err := s.Stat(ctx, "a b/c%d.txt"); !errors.Is(err, ErrNotExist) {
+		t.Errorf("Stat of renamed file: got %v", err)
+	}
+	if fi, err := s.Stat(ctx, "renamed/c%d ?.txt"); err != nil || fi.Size != int64(len("escaped")) {
+		t.Errorf("Stat of new file: got %v %v", fi, err)
+	}
+	if err := s.Rename(ctx, "1/1_p0.jpg", "renamed/c%d ?.txt"); !errors.Is(err, ErrExist) {
+		t.Errorf("Rename to existing file: got %v", err)
+	}
+
+	if err := s.Remove(ctx, "1/1_p1.png"); err != nil {
+		t.Fatal("Remove:", err)
+	}
+	if _, err := s.Stat(ctx, "1/1_p1.png"); !errors.Is(err, ErrNotExist) {
+		t.Errorf("Stat of removed file: got %v", err)
+	}
+}
+
+func TestLocal(t *testing.T) {
+	testStorage(t, NewLocal(t.TempDir()))
+}
+
+func TestCopyAndRemove(t *testing.T) {
+	dir := t.TempDir()
+	src, dst := filepath.Join(dir, "src"), filepath.Join(dir, "dst")
+	if err := ioutil.WriteFile(src, []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := copyAndRemove(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadFile(dst); err != nil || string(b) != "content" {
+		t.Errorf("got %q, %v", b, err)
+	}
+	if _, err := os.Stat(src); !os.IsNotExist(err) {
+		t.Error("the source file is not removed")
+	}
+	if _, err := os.Stat(dst + ".tmp"); !os.IsNotExist(err) {
+		t.Error("the temporary file is left")
+	}
+}
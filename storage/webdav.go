@@ -0,0 +1,290 @@
+package storage
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// WebDAV stores the files on a WebDAV server.
+type WebDAV struct {
+	root     *url.URL
+	username string
+	password string
+	Client   *http.Client
+}
+
+// NewWebDAV returns a WebDAV storage with the root URL of the collection.
+// http.DefaultClient is used if client is nil.
+func NewWebDAV(root, username, password string, client *http.Client) (*WebDAV, error) {
+	u, err := url.Parse(root)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("webdav: invalid URL: %q", root)
+	}
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &WebDAV{root: u, username: username, password: password, Client: client}, nil
+}
+
+func (w *WebDAV) url(name string) string {
+	u := *w.root
+	u.Path += CleanName(name)
+	if strings.HasSuffix(name, "/") && !strings.HasSuffix(u.Path, "/") {
+		// collections
+		u.Path += "/"
+	}
+	u.RawPath = ""
+	return u.String()
+}
+
+func (w *WebDAV) do(ctx context.Context, method, name string, body io.Reader, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, w.url(name), body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if w.username != "" || w.password != "" {
+		req.SetBasicAuth(w.username, w.password)
+	}
+	return w.Client.Do(req)
+}
+
+func webdavError(method, name string, resp *http.Response) error {
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("webdav: %s %q: %w", method, name, ErrNotExist)
+	}
+	return fmt.Errorf("webdav: %s %q: %s", method, name, resp.Status)
+}
+
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:"><d:prop><d:resourcetype/><d:getcontentlength/><d:getlastmodified/></d:prop></d:propfind>`
+
+type davMultistatus struct {
+	Responses []davResponse `xml:"DAV: response"`
+}
+
+type davResponse struct {
+	Href      string        `xml:"DAV: href"`
+	Propstats []davPropstat `xml:"DAV: propstat"`
+}
+
+type davPropstat struct {
+	Prop   davProp `xml:"DAV: prop"`
+	Status string  `xml:"DAV: status"`
+}
+
+type davProp struct {
+	ContentLength int64  `xml:"DAV: getcontentlength"`
+	LastModified  string `xml:"DAV: getlastmodified"`
+	ResourceType  struct {
+		Collection *struct{} `xml:"DAV: collection"`
+	} `xml:"DAV: resourcetype"`
+}
+
+type davEntry struct {
+	FileInfo
+	dir bool
+}
+
+// propfind lists the resource of name, and its members if depth is "1".
+func (w *WebDAV) propfind(ctx context.Context, name, depth string) ([]davEntry, error) {
+	resp, err := w.do(ctx, "PROPFIND", name, strings.NewReader(propfindBody), http.Header{
+		"Depth":        {depth},
+		"Content-Type": {"application/xml; charset=utf-8"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, webdavError("PROPFIND", name, resp)
+	}
+
+	ms := &davMultistatus{}
+	if err := xml.NewDecoder(resp.Body).Decode(ms); err != nil {
+		return nil, fmt.Errorf("webdav: PROPFIND %q: %w", name, err)
+	}
+	es := make([]davEntry, 0, len(ms.Responses))
+	for _, r := range ms.Responses {
+		u, err := url.Parse(r.Href)
+		if err != nil {
+			return nil, err
+		}
+		e := davEntry{}
+		e.Name = strings.Trim(strings.TrimPrefix(u.Path, w.root.Path), "/")
+		for _, ps := range r.Propstats {
+			if !strings.Contains(ps.Status, " 200 ") {
+				continue
+			}
+			e.Size = ps.Prop.ContentLength
+			e.dir = ps.Prop.ResourceType.Collection != nil
+			if t, err := http.ParseTime(ps.Prop.LastModified); err == nil {
+				e.ModTime = t
+			}
+		}
+		es = append(es, e)
+	}
+	return es, nil
+}
+
+// Stat implements Storage.
+func (w *WebDAV) Stat(ctx context.Context, name string) (*FileInfo, error) {
+	es, err := w.propfind(ctx, name, "0")
+	if err != nil {
+		return nil, err
+	}
+	if len(es) == 0 {
+		return nil, fmt.Errorf("webdav: PROPFIND %q: empty response", name)
+	}
+	fi := es[0].FileInfo
+	fi.Name = CleanName(name)
+	return &fi, nil
+}
+
+// Open implements Storage.
+func (w *WebDAV) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	resp, err := w.do(ctx, http.MethodGet, name, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, webdavError(http.MethodGet, name, resp)
+	}
+	return resp.Body, nil
+}
+
+// mkcolAll creates the parent collections of name.
+func (w *WebDAV) mkcolAll(ctx context.Context, name string) error {
+	dir := path.Dir(CleanName(name))
+	if dir == "." {
+		return nil
+	}
+	p := ""
+	for _, s := range strings.Split(dir, "/") {
+		p += s + "/"
+		resp, err := w.do(ctx, "MKCOL", p, nil, nil)
+		if err != nil {
+			return err
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		// 405 Method Not Allowed means the collection exists
+		if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusMethodNotAllowed {
+			return webdavError("MKCOL", p, resp)
+		}
+	}
+	return nil
+}
+
+// Put implements Storage.
+func (w *WebDAV) Put(ctx context.Context, name string, r io.ReadSeeker) error {
+	if err := w.mkcolAll(ctx, name); err != nil {
+		return err
+	}
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, w.url(name), ioutil.NopCloser(r))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	if w.username != "" || w.password != "" {
+		req.SetBasicAuth(w.username, w.password)
+	}
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return webdavError(http.MethodPut, name, resp)
+	}
+	return nil
+}
+
+// Remove implements Storage.
+func (w *WebDAV) Remove(ctx context.Context, name string) error {
+	resp, err := w.do(ctx, http.MethodDelete, name, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return webdavError(http.MethodDelete, name, resp)
+	}
+	return nil
+}
+
+// Rename implements Storage.
+func (w *WebDAV) Rename(ctx context.Context, oldName, newName string) error {
+	if err := w.mkcolAll(ctx, newName); err != nil {
+		return err
+	}
+	resp, err := w.do(ctx, "MOVE", oldName, nil, http.Header{
+		"Destination": {w.url(newName)},
+		"Overwrite":   {"F"},
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusPreconditionFailed {
+		return fmt.Errorf("webdav: MOVE %q to %q: %w", oldName, newName, ErrExist)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return webdavError("MOVE", oldName, resp)
+	}
+	return nil
+}
+
+// Walk implements Storage.
+// The collections are listed one by one as many servers refuse "Depth: infinity".
+func (w *WebDAV) Walk(ctx context.Context, fn func(fi *FileInfo) error) error {
+	return w.walk(ctx, "", fn)
+}
+
+func (w *WebDAV) walk(ctx context.Context, dir string, fn func(fi *FileInfo) error) error {
+	es, err := w.propfind(ctx, dir+"/", "1")
+	if err != nil {
+		return err
+	}
+	for _, e := range es {
+		if e.Name == strings.Trim(dir, "/") {
+			// the collection itself
+			continue
+		}
+		if e.dir {
+			if err := w.walk(ctx, e.Name, fn); err != nil {
+				return err
+			}
+			continue
+		}
+		fi := e.FileInfo
+		if err := fn(&fi); err != nil {
+			return err
+		}
+	}
+	return nil
+}
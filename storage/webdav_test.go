@@ -0,0 +1,23 @@
+package storage
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/net/webdav"
+)
+
+func TestWebDAV(t *testing.T) {
+	ts := httptest.NewServer(&webdav.Handler{
+		Prefix:     "/dav",
+		FileSystem: webdav.NewMemFS(),
+		LockSystem: webdav.NewMemLS(),
+	})
+	defer ts.Close()
+
+	s, err := NewWebDAV(ts.URL+"/dav", "", "", ts.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStorage(t, s)
+}
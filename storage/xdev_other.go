@@ -0,0 +1,9 @@
+//go:build !windows
+// +build !windows
+
+package storage
+
+import "syscall"
+
+// errCrossDevice is the error of renaming a file to another filesystem.
+var errCrossDevice error = syscall.EXDEV
@@ -0,0 +1,6 @@
+package storage
+
+import "golang.org/x/sys/windows"
+
+// errCrossDevice is the error of renaming a file to another volume.
+var errCrossDevice error = windows.ERROR_NOT_SAME_DEVICE